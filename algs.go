@@ -26,8 +26,10 @@ func Sources(index *Index) []Node {
 		}
 	}
 	ret := make([]Node, 0, len(nodeMap))
-	for node := range nodeMap {
-		ret = append(ret, node)
+	for _, node := range index.NodesSlice() {
+		if _, ok := nodeMap[node]; ok {
+			ret = append(ret, node)
+		}
 	}
 	return ret
 }
@@ -92,10 +94,13 @@ func CopyGraph(target, source *Graph, copyNode func(Node) Node, copyEdge func(Ed
 	return Copy(target, ix, copyNode, copyEdge)
 }
 
-// IterateGraph iterates all nodes and edges of the graph until one of the functions returns false
+// IterateGraph iterates all nodes and edges of the graph until one of
+// the functions returns false. The graph is traversed depth-first
+// starting from the nodes added to the graph, in the order they are
+// added.
 func IterateGraph(g *Graph, nodeFunc func(Node) bool, edgeFunc func(Edge) bool) bool {
 	seen := make(map[Node]struct{})
-	for node := range g.nodes {
+	for _, node := range g.roots() {
 		if !IterateUnique(node, nodeFunc, edgeFunc, seen) {
 			return false
 		}
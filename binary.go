@@ -0,0 +1,502 @@
+package digraph
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+)
+
+// The binary format starts with the magic string and the version,
+// followed by records. Each record starts with a tag:
+//
+//	label: codec encoded label. Labels are numbered from 1 in the
+//	       order they are written
+//	node:  uvarint stable ID, flags, uvarint label number (0 for nil),
+//	       length-prefixed codec encoded data. Nodes are numbered from
+//	       0 in the order they are written
+//	edge:  uvarint source node number, uvarint target node number,
+//	       uvarint label number, length-prefixed codec encoded data
+//
+// Lengths are uvarints, and a zero length means nil data.
+const (
+	binaryMagic   = "DGRF"
+	binaryVersion = 1
+
+	binaryTagLabel = 1
+	binaryTagNode  = 2
+	binaryTagEdge  = 3
+
+	binaryFlagRoot = 1
+)
+
+// ErrInvalidFormat is returned when decoding data that is not a binary
+// encoded graph, or is corrupt
+var ErrInvalidFormat = errors.New("invalid binary graph format")
+
+// ErrUnsupportedVersion is returned when decoding a binary encoded
+// graph written by a newer version of the format
+var ErrUnsupportedVersion = errors.New("unsupported binary graph format version")
+
+// Codec encodes labels and node and edge data of the binary format
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// gobValue wraps values so gob encodes them as interfaces
+type gobValue struct {
+	V interface{}
+}
+
+// gobCodec keeps a gob stream open, so type information is written
+// only once
+type gobCodec struct {
+	wbuf bytes.Buffer
+	rbuf bytes.Buffer
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+// NewGobCodec returns a codec that uses encoding/gob. Values of
+// types other than the basic types must be registered using
+// gob.Register. The codec is stateful: values must be unmarshaled in
+// the order they were marshaled, so a codec can only be used by one
+// encoder or decoder.
+func NewGobCodec() Codec {
+	c := &gobCodec{}
+	c.enc = gob.NewEncoder(&c.wbuf)
+	c.dec = gob.NewDecoder(&c.rbuf)
+	return c
+}
+
+func (c *gobCodec) Marshal(v interface{}) ([]byte, error) {
+	c.wbuf.Reset()
+	if err := c.enc.Encode(gobValue{V: v}); err != nil {
+		return nil, err
+	}
+	return c.wbuf.Bytes(), nil
+}
+
+func (c *gobCodec) Unmarshal(data []byte) (interface{}, error) {
+	c.rbuf.Reset()
+	c.rbuf.Write(data)
+	var v gobValue
+	if err := c.dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v.V, nil
+}
+
+// Encoder writes nodes and edges in the binary format. Nodes are
+// written before the edges that refer to them.
+type Encoder struct {
+	// Codec encodes labels and data. Default is a gob codec
+	Codec Codec
+	// NodeData returns the data written with a node. If nil, the
+	// payload of a BasicNode is written
+	NodeData func(Node) interface{}
+	// EdgeData returns the data written with an edge. If nil, the
+	// payload of a BasicEdge is written
+	EdgeData func(Edge) interface{}
+
+	w       *bufio.Writer
+	started bool
+	nodes   map[Node]uint64
+	labels  map[interface{}]uint64
+	buf     []byte
+}
+
+// NewEncoder returns a new encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:      bufio.NewWriter(w),
+		nodes:  make(map[Node]uint64),
+		labels: make(map[interface{}]uint64),
+		buf:    make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+func (e *Encoder) uvarint(x uint64) error {
+	n := binary.PutUvarint(e.buf, x)
+	_, err := e.w.Write(e.buf[:n])
+	return err
+}
+
+// bytes writes length-prefixed data
+func (e *Encoder) bytes(data []byte) error {
+	if err := e.uvarint(uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := e.w.Write(data)
+	return err
+}
+
+// marshal encodes a value using the codec. Returns nil for a nil
+// value, which is written as zero length
+func (e *Encoder) marshal(v interface{}) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return e.Codec.Marshal(v)
+}
+
+func (e *Encoder) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	if e.Codec == nil {
+		e.Codec = NewGobCodec()
+	}
+	if _, err := e.w.WriteString(binaryMagic); err != nil {
+		return err
+	}
+	return e.w.WriteByte(binaryVersion)
+}
+
+// label returns the number of the label, writing it to the dictionary
+// if necessary
+func (e *Encoder) label(label interface{}) (uint64, error) {
+	if label == nil {
+		return 0, nil
+	}
+	key := LabelKey(label)
+	if n, ok := e.labels[key]; ok {
+		return n, nil
+	}
+	// The label is marshaled first, so nothing is written if it
+	// cannot be encoded
+	data, err := e.Codec.Marshal(label)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.w.WriteByte(binaryTagLabel); err != nil {
+		return 0, err
+	}
+	if err := e.bytes(data); err != nil {
+		return 0, err
+	}
+	n := uint64(len(e.labels) + 1)
+	e.labels[key] = n
+	return n, nil
+}
+
+// EncodeNode writes a node. A node is written only once. The node is
+// marked as a root if it was added to the graph it belongs to using
+// AddNode.
+func (e *Encoder) EncodeNode(node Node) error {
+	root := false
+	if g := node.getNodeHeader().graph; g != nil {
+		_, root = g.nodes[node]
+	}
+	return e.encodeNode(node, root)
+}
+
+// encodeNode writes a node with the given root flag if it was not
+// written before
+func (e *Encoder) encodeNode(node Node, root bool) error {
+	if _, ok := e.nodes[node]; ok {
+		return nil
+	}
+	if err := e.start(); err != nil {
+		return err
+	}
+	label, err := e.label(node.GetLabel())
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if e.NodeData != nil {
+		value = e.NodeData(node)
+	} else if basic, ok := node.(*BasicNode); ok {
+		value = basic.Payload
+	}
+	// The data is marshaled before the record is written, so nothing
+	// is written if it cannot be encoded
+	data, err := e.marshal(value)
+	if err != nil {
+		return err
+	}
+	var flags byte
+	if root {
+		flags |= binaryFlagRoot
+	}
+	if err := e.w.WriteByte(binaryTagNode); err != nil {
+		return err
+	}
+	if err := e.uvarint(node.ID()); err != nil {
+		return err
+	}
+	if err := e.w.WriteByte(flags); err != nil {
+		return err
+	}
+	if err := e.uvarint(label); err != nil {
+		return err
+	}
+	if err := e.bytes(data); err != nil {
+		return err
+	}
+	e.nodes[node] = uint64(len(e.nodes))
+	return nil
+}
+
+// EncodeEdge writes an edge. The source and target nodes are written
+// first if they were not written before. Returns ErrEdgeNotConnected
+// if the edge is not connected.
+func (e *Encoder) EncodeEdge(edge Edge) error {
+	from, to := edge.GetFrom(), edge.GetTo()
+	if from == nil || to == nil {
+		return ErrEdgeNotConnected
+	}
+	if err := e.EncodeNode(from); err != nil {
+		return err
+	}
+	if err := e.EncodeNode(to); err != nil {
+		return err
+	}
+	label, err := e.label(edge.GetLabel())
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if e.EdgeData != nil {
+		value = e.EdgeData(edge)
+	} else if basic, ok := edge.(*BasicEdge); ok {
+		value = basic.Payload
+	}
+	data, err := e.marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := e.w.WriteByte(binaryTagEdge); err != nil {
+		return err
+	}
+	if err := e.uvarint(e.nodes[from]); err != nil {
+		return err
+	}
+	if err := e.uvarint(e.nodes[to]); err != nil {
+		return err
+	}
+	if err := e.uvarint(label); err != nil {
+		return err
+	}
+	return e.bytes(data)
+}
+
+// Encode writes all nodes and edges of the graph, and flushes the
+// output. Stable IDs are assigned to nodes that do not have one. The
+// nodes added to g using AddNode are marked as roots, whichever graph
+// they belong to.
+func (e *Encoder) Encode(g *Graph) error {
+	g.AssignIDs()
+	nodes := g.GetAllNodes().All()
+	for _, node := range nodes {
+		_, root := g.nodes[node]
+		if err := e.encodeNode(node, root); err != nil {
+			return err
+		}
+	}
+	for _, node := range nodes {
+		for edges := node.Out(); edges.HasNext(); {
+			if err := e.EncodeEdge(edges.Next()); err != nil {
+				return err
+			}
+		}
+	}
+	return e.Flush()
+}
+
+// Flush writes any buffered data to the underlying writer
+func (e *Encoder) Flush() error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// Decoder reads a graph in the binary format
+type Decoder struct {
+	// Codec decodes labels and data. Default is a gob codec
+	Codec Codec
+	// NewNode creates a node with the given label and data. If nil, a
+	// BasicNode with the data as payload is created
+	NewNode func(label, data interface{}) (Node, error)
+	// NewEdge creates an edge with the given label and data. If nil, a
+	// BasicEdge with the data as payload is created
+	NewEdge func(label, data interface{}) (Edge, error)
+
+	r      *bufio.Reader
+	nodes  []Node
+	labels []interface{}
+}
+
+// NewDecoder returns a new decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+func (d *Decoder) uvarint() (uint64, error) {
+	x, err := binary.ReadUvarint(d.r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return x, err
+}
+
+// value reads a length-prefixed codec encoded value
+func (d *Decoder) value() (interface{}, error) {
+	n, err := d.uvarint()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	// The length is not trusted for allocation, so a corrupt length
+	// fails at the end of input
+	data, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return d.Codec.Unmarshal(data)
+}
+
+func (d *Decoder) label() (interface{}, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.labels)) {
+		return nil, ErrInvalidFormat
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return d.labels[n-1], nil
+}
+
+func (d *Decoder) node() (Node, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n >= uint64(len(d.nodes)) {
+		return nil, ErrInvalidFormat
+	}
+	return d.nodes[n], nil
+}
+
+// Decode reads nodes and edges until the end of input, and adds them
+// to the graph. Nodes that were roots when they were encoded are added
+// using AddNode, and edges are connected using Graph.Connect. Nodes
+// keep the stable IDs they were encoded with.
+func (d *Decoder) Decode(g *Graph) error {
+	if d.Codec == nil {
+		d.Codec = NewGobCodec()
+	}
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return ErrInvalidFormat
+	}
+	if string(header[:len(binaryMagic)]) != binaryMagic {
+		return ErrInvalidFormat
+	}
+	if header[len(binaryMagic)] > binaryVersion {
+		return ErrUnsupportedVersion
+	}
+	for {
+		tag, err := d.r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tag {
+		case binaryTagLabel:
+			label, err := d.value()
+			if err != nil {
+				return err
+			}
+			d.labels = append(d.labels, label)
+		case binaryTagNode:
+			if err := d.decodeNode(g); err != nil {
+				return err
+			}
+		case binaryTagEdge:
+			if err := d.decodeEdge(g); err != nil {
+				return err
+			}
+		default:
+			return ErrInvalidFormat
+		}
+	}
+}
+
+func (d *Decoder) decodeNode(g *Graph) error {
+	id, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	flags, err := d.r.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	label, err := d.label()
+	if err != nil {
+		return err
+	}
+	data, err := d.value()
+	if err != nil {
+		return err
+	}
+	var node Node
+	if d.NewNode != nil {
+		if node, err = d.NewNode(label, data); err != nil {
+			return err
+		}
+	} else {
+		node = NewBasicNode(label, data)
+	}
+	if hdr := node.getNodeHeader(); hdr.id == 0 {
+		setNodeID(hdr, id)
+	}
+	if flags&binaryFlagRoot != 0 {
+		if err := g.TryAddNode(node); err != nil {
+			return err
+		}
+	}
+	d.nodes = append(d.nodes, node)
+	return nil
+}
+
+func (d *Decoder) decodeEdge(g *Graph) error {
+	from, err := d.node()
+	if err != nil {
+		return err
+	}
+	to, err := d.node()
+	if err != nil {
+		return err
+	}
+	label, err := d.label()
+	if err != nil {
+		return err
+	}
+	data, err := d.value()
+	if err != nil {
+		return err
+	}
+	var edge Edge
+	if d.NewEdge != nil {
+		if edge, err = d.NewEdge(label, data); err != nil {
+			return err
+		}
+	} else {
+		edge = NewBasicEdge(label, data)
+	}
+	return g.Connect(from, to, edge)
+}
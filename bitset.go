@@ -0,0 +1,54 @@
+package digraph
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// Bitset is a fixed size set of non-negative integers
+type Bitset []uint64
+
+// NewBitset returns a bitset that can hold integers in [0,n)
+func NewBitset(n int) Bitset {
+	return make(Bitset, (n+63)/64)
+}
+
+// Set adds i to the set
+func (b Bitset) Set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+// Clear removes i from the set
+func (b Bitset) Clear(i int) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+// Test returns true if i is in the set
+func (b Bitset) Test(i int) bool {
+	return b[i/64]&(1<<uint(i%64)) != 0
+}
+
+// Count returns the number of elements in the set
+func (b Bitset) Count() int {
+	n := 0
+	for _, w := range b {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// testAndSet atomically adds i to the set. Returns true if i was not
+// in the set
+func (b Bitset) testAndSet(i int) bool {
+	addr := &b[i/64]
+	mask := uint64(1) << uint(i%64)
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return true
+		}
+	}
+}
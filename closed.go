@@ -0,0 +1,113 @@
+package digraph
+
+import (
+	"errors"
+)
+
+// ErrForeignNode is returned when a node that belongs to a different
+// graph is added to, or connected to a node of a closed graph
+var ErrForeignNode = errors.New("node belongs to a different graph")
+
+// NewClosed returns a new empty closed graph. A closed graph keeps
+// track of all its nodes: every node added to the graph, or connected
+// to a node of the graph becomes a member of the graph, along with
+// the nodes accessible from it, and their edges are counted. This makes
+// NodeCount, EdgeCount, and Contains O(1), and makes isolated nodes
+// visible to the indexes of the graph.
+//
+// Nodes of a closed graph cannot be added to, or connected to nodes
+// of other graphs, and nodes of other graphs cannot be connected to
+// the nodes of a closed graph. Such operations return
+// ErrForeignNode, or panic if they do not return an error.
+func NewClosed() *Graph {
+	g := New()
+	g.closed = true
+	return g
+}
+
+// IsClosed returns true if the graph is a closed graph
+func (g *Graph) IsClosed() bool {
+	return g.closed
+}
+
+// checkForeign returns ErrForeignNode if node belongs to another graph,
+// and either of the graphs is closed. If node does not belong to a
+// graph, the nodes accessible from it join g with it, so they are
+// checked as well.
+func (g *Graph) checkForeign(node Node) error {
+	if g.isForeign(node) {
+		return ErrForeignNode
+	}
+	if node.getNodeHeader().graph != nil {
+		return nil
+	}
+	seen := map[Node]struct{}{node: {}}
+	stack := []Node{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range n.Next() {
+			if _, ok := seen[next]; ok {
+				continue
+			}
+			seen[next] = struct{}{}
+			if g.isForeign(next) {
+				return ErrForeignNode
+			}
+			if next.getNodeHeader().graph == nil {
+				stack = append(stack, next)
+			}
+		}
+	}
+	return nil
+}
+
+// isForeign returns true if node belongs to another graph, and either
+// of the graphs is closed. g can be nil
+func (g *Graph) isForeign(node Node) bool {
+	owner := node.getNodeHeader().graph
+	return owner != nil && owner != g && ((g != nil && g.closed) || owner.closed)
+}
+
+// NodeCount returns the number of nodes of the graph. This is O(1) for
+// closed graphs. For other graphs, the graph is traversed to count the
+// accessible nodes.
+func (g *Graph) NodeCount() int {
+	if g.closed {
+		return len(g.nodes)
+	}
+	return len(g.GetIndex().NodesSlice())
+}
+
+// EdgeCount returns the number of edges of the graph. This is O(1) for
+// closed graphs. For other graphs, the graph is traversed to count the
+// accessible edges.
+func (g *Graph) EdgeCount() int {
+	if g.closed {
+		return g.edgeCount
+	}
+	n := 0
+	IterateGraph(g, func(Node) bool { return true }, func(Edge) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Contains returns true if the node is a node of the graph. This is
+// O(1) for closed graphs. For other graphs, the graph is traversed to
+// find the node.
+func (g *Graph) Contains(node Node) bool {
+	if _, ok := g.nodes[node]; ok {
+		return true
+	}
+	if g.closed {
+		return false
+	}
+	found := false
+	IterateGraph(g, func(n Node) bool {
+		found = n == node
+		return !found
+	}, func(Edge) bool { return true })
+	return found
+}
@@ -0,0 +1,376 @@
+package digraph
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrMissingColumn is returned when a column of an edge list is not in
+// the header, or a record does not have the column
+var ErrMissingColumn = errors.New("missing column")
+
+// ErrEmptyID is returned when a record of an edge list has an empty
+// source or target ID, or a line of an adjacency list does not start
+// with a node ID
+var ErrEmptyID = errors.New("empty node ID")
+
+// CSVRecord is a record of an edge list
+type CSVRecord struct {
+	// Header is the header row, or nil if the edge list does not have
+	// a header
+	Header []string
+	Fields []string
+	// Line is the number of the record in the input starting from 1,
+	// including the header. It is the line number unless there are
+	// quoted fields with newlines
+	Line int
+}
+
+// Get returns the value of the named column, or the column with the
+// given number if there is no header. Returns false if there is no
+// such column.
+func (r CSVRecord) Get(column string) (string, bool) {
+	i, ok := columnIndex(r.Header, column)
+	if !ok || i >= len(r.Fields) {
+		return "", false
+	}
+	return r.Fields[i], true
+}
+
+// columnIndex returns the index of a column in the header, or the
+// column number if there is no header
+func columnIndex(header []string, column string) (int, bool) {
+	if header == nil {
+		i, err := strconv.Atoi(column)
+		return i, err == nil && i >= 0
+	}
+	for i, name := range header {
+		if name == column {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// newNodeByID returns a node for the given ID, creating it if this is
+// the first time the ID is seen
+func newNodeByID(g *Graph, nodes map[string]Node, id string, newNode func(string) (Node, error)) (Node, error) {
+	if node, ok := nodes[id]; ok {
+		return node, nil
+	}
+	var node Node
+	if newNode != nil {
+		var err error
+		if node, err = newNode(id); err != nil {
+			return nil, err
+		}
+	} else {
+		node = NewBasicNode(id, nil)
+	}
+	if err := g.TryAddNode(node); err != nil {
+		return nil, err
+	}
+	nodes[id] = node
+	return node, nil
+}
+
+// EdgeListReader reads a graph from a CSV or TSV edge list. Each
+// record is an edge, and contains the IDs of the source and target
+// nodes, an optional edge label, and any number of other columns.
+type EdgeListReader struct {
+	// Comma is the field delimiter. Default is ','. Use '\t' for TSV.
+	// Leading white space of fields is ignored unless the delimiter is
+	// white space, so empty TSV fields are kept
+	Comma rune
+	// Header is true if the first record is the header
+	Header bool
+	// FromColumn, ToColumn, and LabelColumn are the names of the
+	// columns if there is a header, or the column numbers starting
+	// from 0 if there is no header. Defaults are from, to, and label
+	// with a header, and 0, 1, and 2 without a header. Edges do not
+	// have labels if the label column is not in the header, or if
+	// LabelColumn is "-".
+	FromColumn, ToColumn, LabelColumn string
+	// NewNode creates the node with the given ID. If nil, a BasicNode
+	// labeled with the ID is created
+	NewNode func(id string) (Node, error)
+	// NewEdge creates the edge for a record. If nil, a BasicEdge
+	// labeled with the value of the label column is created, or an
+	// unlabeled edge if there is no label column
+	NewEdge func(record CSVRecord) (Edge, error)
+}
+
+// Read reads the edge list, and adds the nodes and edges to the
+// graph. Nodes are created the first time their IDs are seen. Edges
+// are connected using Graph.Connect, so the policies of the graph are
+// enforced. Returns the nodes by their IDs. Records with empty source
+// or target IDs are rejected with ErrEmptyID.
+func (r EdgeListReader) Read(g *Graph, in io.Reader) (map[string]Node, error) {
+	reader := csv.NewReader(in)
+	if r.Comma != 0 {
+		reader.Comma = r.Comma
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = !unicode.IsSpace(reader.Comma)
+	fromCol, toCol, labelCol := r.FromColumn, r.ToColumn, r.LabelColumn
+	var header []string
+	if r.Header {
+		var err error
+		if header, err = reader.Read(); err != nil {
+			return nil, err
+		}
+		if fromCol == "" {
+			fromCol = "from"
+		}
+		if toCol == "" {
+			toCol = "to"
+		}
+		if labelCol == "" {
+			labelCol = "label"
+		}
+		for _, col := range []string{fromCol, toCol} {
+			if _, ok := columnIndex(header, col); !ok {
+				return nil, fmt.Errorf("%w: %s", ErrMissingColumn, col)
+			}
+		}
+	} else {
+		if fromCol == "" {
+			fromCol = "0"
+		}
+		if toCol == "" {
+			toCol = "1"
+		}
+		if labelCol == "" {
+			labelCol = "2"
+		}
+	}
+	nodes := make(map[string]Node)
+	line := 0
+	if r.Header {
+		line = 1
+	}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nodes, err
+		}
+		line++
+		record := CSVRecord{Header: header, Fields: fields, Line: line}
+		fromID, ok1 := record.Get(fromCol)
+		toID, ok2 := record.Get(toCol)
+		if !ok1 || !ok2 {
+			return nodes, fmt.Errorf("line %d: %w", line, ErrMissingColumn)
+		}
+		if fromID == "" || toID == "" {
+			return nodes, fmt.Errorf("line %d: %w", line, ErrEmptyID)
+		}
+		from, err := newNodeByID(g, nodes, fromID, r.NewNode)
+		if err != nil {
+			return nodes, fmt.Errorf("line %d: %w", line, err)
+		}
+		to, err := newNodeByID(g, nodes, toID, r.NewNode)
+		if err != nil {
+			return nodes, fmt.Errorf("line %d: %w", line, err)
+		}
+		var edge Edge
+		if r.NewEdge != nil {
+			if edge, err = r.NewEdge(record); err != nil {
+				return nodes, fmt.Errorf("line %d: %w", line, err)
+			}
+		} else if label, ok := record.Get(labelCol); ok && labelCol != "-" {
+			edge = NewBasicEdge(label, nil)
+		} else {
+			edge = NewBasicEdge(nil, nil)
+		}
+		if err := g.Connect(from, to, edge); err != nil {
+			return nodes, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return nodes, nil
+}
+
+// EdgeListWriter writes the edges of a graph as a CSV or TSV edge
+// list. Nodes without edges are not written.
+type EdgeListWriter struct {
+	// Comma is the field delimiter. Default is ','. Use '\t' for TSV
+	Comma rune
+	// Header, if set, is written as the first record. It should name
+	// the from, to, and label columns followed by the extra columns
+	Header []string
+	// NodeID returns the ID of a node. IDs must be unique. If nil, the
+	// node label is printed using fmt.Sprint
+	NodeID func(Node) string
+	// EdgeLabel returns the label of an edge. If nil, the edge label is
+	// printed using fmt.Sprint, or empty if the edge has no label
+	EdgeLabel func(Edge) string
+	// Columns, if set, returns the extra columns of an edge
+	Columns func(Edge) []string
+}
+
+// Write writes the edge list
+func (w EdgeListWriter) Write(g *Graph, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	if w.Comma != 0 {
+		writer.Comma = w.Comma
+	}
+	if w.Header != nil {
+		if err := writer.Write(w.Header); err != nil {
+			return err
+		}
+	}
+	nodeID := w.NodeID
+	if nodeID == nil {
+		nodeID = defaultNodeID
+	}
+	for _, node := range g.GetAllNodes().All() {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			label := ""
+			if w.EdgeLabel != nil {
+				label = w.EdgeLabel(edge)
+			} else if lbl := edge.GetLabel(); lbl != nil {
+				label = fmt.Sprint(lbl)
+			}
+			record := []string{nodeID(node), nodeID(edge.GetTo()), label}
+			if w.Columns != nil {
+				record = append(record, w.Columns(edge)...)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func defaultNodeID(node Node) string {
+	return fmt.Sprint(node.GetLabel())
+}
+
+// AdjacencyListReader reads a graph from an adjacency list. Each line
+// contains the ID of a node followed by the IDs of the targets of its
+// outgoing edges. Empty lines and lines starting with the comment
+// prefix are ignored.
+type AdjacencyListReader struct {
+	// Delimiter separates the IDs in a line. If empty, IDs are
+	// separated by white space. Empty target IDs, such as the one
+	// after a trailing delimiter, are ignored. A line that starts with
+	// the delimiter is an error.
+	Delimiter string
+	// Comment is the comment prefix. Default is #
+	Comment string
+	// NewNode creates the node with the given ID. If nil, a BasicNode
+	// labeled with the ID is created
+	NewNode func(id string) (Node, error)
+	// NewEdge creates the edge between the given nodes. If nil, an
+	// unlabeled BasicEdge is created
+	NewEdge func(fromID, toID string) (Edge, error)
+}
+
+// Read reads the adjacency list, and adds the nodes and edges to the
+// graph. Returns the nodes by their IDs.
+func (r AdjacencyListReader) Read(g *Graph, in io.Reader) (map[string]Node, error) {
+	comment := r.Comment
+	if comment == "" {
+		comment = "#"
+	}
+	nodes := make(map[string]Node)
+	// Lines are read without a length limit, so nodes can have any
+	// number of edges
+	reader := bufio.NewReader(in)
+	line := 0
+	for done := false; !done; {
+		text, err := reader.ReadString('\n')
+		if err == io.EOF {
+			done = true
+		} else if err != nil {
+			return nodes, err
+		}
+		line++
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, comment) {
+			continue
+		}
+		var ids []string
+		if r.Delimiter == "" {
+			ids = strings.Fields(text)
+		} else {
+			fields := strings.Split(text, r.Delimiter)
+			ids = make([]string, 0, len(fields))
+			for i, field := range fields {
+				id := strings.TrimSpace(field)
+				if id == "" {
+					if i == 0 {
+						return nodes, fmt.Errorf("line %d: %w", line, ErrEmptyID)
+					}
+					continue
+				}
+				ids = append(ids, id)
+			}
+		}
+		from, err := newNodeByID(g, nodes, ids[0], r.NewNode)
+		if err != nil {
+			return nodes, fmt.Errorf("line %d: %w", line, err)
+		}
+		for _, id := range ids[1:] {
+			to, err := newNodeByID(g, nodes, id, r.NewNode)
+			if err != nil {
+				return nodes, fmt.Errorf("line %d: %w", line, err)
+			}
+			var edge Edge
+			if r.NewEdge != nil {
+				if edge, err = r.NewEdge(ids[0], id); err != nil {
+					return nodes, fmt.Errorf("line %d: %w", line, err)
+				}
+			} else {
+				edge = NewBasicEdge(nil, nil)
+			}
+			if err := g.Connect(from, to, edge); err != nil {
+				return nodes, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// AdjacencyListWriter writes a graph as an adjacency list. Edge labels
+// are not written.
+type AdjacencyListWriter struct {
+	// Delimiter separates the IDs in a line. Default is a space
+	Delimiter string
+	// NodeID returns the ID of a node. IDs must be unique and must not
+	// contain the delimiter. If nil, the node label is printed using
+	// fmt.Sprint
+	NodeID func(Node) string
+}
+
+// Write writes a line for each node of the graph
+func (w AdjacencyListWriter) Write(g *Graph, out io.Writer) error {
+	delim := w.Delimiter
+	if delim == "" {
+		delim = " "
+	}
+	nodeID := w.NodeID
+	if nodeID == nil {
+		nodeID = defaultNodeID
+	}
+	ew := &errWriter{w: out}
+	for _, node := range g.GetAllNodes().All() {
+		ids := []string{nodeID(node)}
+		for edges := node.Out(); edges.HasNext(); {
+			ids = append(ids, nodeID(edges.Next().GetTo()))
+		}
+		ew.printf("%s\n", strings.Join(ids, delim))
+	}
+	return ew.err
+}
@@ -0,0 +1,412 @@
+package digraph
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ErrDeltaConflict is returned when a delta refers to nodes or edges
+// that are not in the graph it is applied to, or adds a node that is
+// already in the graph
+var ErrDeltaConflict = errors.New("delta does not apply to graph")
+
+// NodeDelta is a node that is added, removed, or changed. Payloads
+// are the payloads of BasicNodes, and nil for other node types.
+type NodeDelta struct {
+	Key     interface{}
+	Label   interface{}
+	Payload interface{}
+	// OldLabel and OldPayload are set for changed nodes
+	OldLabel   interface{}
+	OldPayload interface{}
+	// Root is true if the node was added to its graph using AddNode
+	Root bool
+}
+
+// EdgeDelta is an edge that is added, removed, or changed. Edges are
+// identified by the keys of their source and target nodes, and their
+// label. Payloads are the payloads of BasicEdges, and nil for other
+// edge types.
+type EdgeDelta struct {
+	From, To interface{}
+	Label    interface{}
+	Payload  interface{}
+	// OldPayload is set for changed edges
+	OldPayload interface{}
+}
+
+// Delta is the difference between two graphs
+type Delta struct {
+	AddedNodes   []NodeDelta
+	RemovedNodes []NodeDelta
+	ChangedNodes []NodeDelta
+	AddedEdges   []EdgeDelta
+	RemovedEdges []EdgeDelta
+	ChangedEdges []EdgeDelta
+	// AddedRoots and RemovedRoots are the keys of the nodes of both
+	// graphs that are added to, or removed from the nodes added to
+	// the graph using AddNode
+	AddedRoots   []interface{}
+	RemovedRoots []interface{}
+
+	// NodeKey returns the key of a node. It is the key function the
+	// delta was computed with, and is used to find nodes when the delta
+	// is applied
+	NodeKey func(Node) interface{}
+	// NewNode creates the node for an added node. If nil, a BasicNode
+	// is created
+	NewNode func(NodeDelta) Node
+	// NewEdge creates the edge for an added edge. If nil, a BasicEdge
+	// is created
+	NewEdge func(EdgeDelta) Edge
+}
+
+// IsEmpty returns true if the delta has no changes
+func (d Delta) IsEmpty() bool {
+	return len(d.AddedNodes)+len(d.RemovedNodes)+len(d.ChangedNodes)+
+		len(d.AddedEdges)+len(d.RemovedEdges)+len(d.ChangedEdges)+
+		len(d.AddedRoots)+len(d.RemovedRoots) == 0
+}
+
+// String returns the changes one per line. Added items are prefixed
+// with +, removed items with -, and changed items with ~.
+func (d Delta) String() string {
+	var b strings.Builder
+	for _, n := range d.RemovedNodes {
+		fmt.Fprintf(&b, "- node %v\n", n.Key)
+	}
+	for _, n := range d.AddedNodes {
+		fmt.Fprintf(&b, "+ node %v\n", n.Key)
+	}
+	for _, n := range d.ChangedNodes {
+		fmt.Fprintf(&b, "~ node %v: label %v -> %v, payload %v -> %v\n", n.Key, n.OldLabel, n.Label, n.OldPayload, n.Payload)
+	}
+	for _, key := range d.RemovedRoots {
+		fmt.Fprintf(&b, "- root %v\n", key)
+	}
+	for _, key := range d.AddedRoots {
+		fmt.Fprintf(&b, "+ root %v\n", key)
+	}
+	for _, e := range d.RemovedEdges {
+		fmt.Fprintf(&b, "- edge %v -[%v]-> %v\n", e.From, e.Label, e.To)
+	}
+	for _, e := range d.AddedEdges {
+		fmt.Fprintf(&b, "+ edge %v -[%v]-> %v\n", e.From, e.Label, e.To)
+	}
+	for _, e := range d.ChangedEdges {
+		fmt.Fprintf(&b, "~ edge %v -[%v]-> %v: payload %v -> %v\n", e.From, e.Label, e.To, e.OldPayload, e.Payload)
+	}
+	return b.String()
+}
+
+func nodePayload(node Node) interface{} {
+	if basic, ok := node.(*BasicNode); ok {
+		return basic.Payload
+	}
+	return nil
+}
+
+func edgePayload(edge Edge) interface{} {
+	if basic, ok := edge.(*BasicEdge); ok {
+		return basic.Payload
+	}
+	return nil
+}
+
+// keyedNodes returns the nodes of the index by the LabelKey of their
+// keys, and the keys in index order
+func keyedNodes(index *Index, nodeKey func(Node) interface{}) (map[interface{}]Node, []interface{}) {
+	nodes := make(map[interface{}]Node)
+	keys := make([]interface{}, 0)
+	for _, node := range index.NodesSlice() {
+		key := nodeKey(node)
+		k := LabelKey(key)
+		if _, ok := nodes[k]; !ok {
+			keys = append(keys, key)
+		}
+		nodes[k] = node
+	}
+	return nodes, keys
+}
+
+// edgeGroup is the edges between two nodes with the same label
+type edgeGroup struct {
+	from, to, label interface{}
+	edges           []Edge
+}
+
+// groupEdges groups the outgoing edges of the nodes by source and
+// target keys, and label. Groups are returned in the order they are
+// first seen.
+func groupEdges(nodes map[interface{}]Node, keys []interface{}, nodeKey func(Node) interface{}) (map[[3]interface{}]*edgeGroup, []*edgeGroup) {
+	groups := make(map[[3]interface{}]*edgeGroup)
+	list := make([]*edgeGroup, 0)
+	for _, key := range keys {
+		for edges := nodes[LabelKey(key)].Out(); edges.HasNext(); {
+			edge := edges.Next()
+			to := nodeKey(edge.GetTo())
+			k := [3]interface{}{LabelKey(key), LabelKey(to), LabelKey(edge.GetLabel())}
+			group, ok := groups[k]
+			if !ok {
+				group = &edgeGroup{from: key, to: to, label: edge.GetLabel()}
+				groups[k] = group
+				list = append(list, group)
+			}
+			group.edges = append(group.edges, edge)
+		}
+	}
+	return groups, list
+}
+
+// Diff returns the changes that turn the graph of index a into the
+// graph of index b. Nodes of the two graphs are matched by the keys
+// returned by nodeKey, which must be unique in each graph. Keys may be
+// any value LabelKey accepts. Edges are matched by the keys of their
+// endpoints and their labels. Parallel edges with the same label are
+// matched by equal payloads first, and the remaining ones are reported
+// as changed, added, or removed. Labels are compared using
+// LabelsEqual, and payloads using reflect.DeepEqual.
+func Diff(a, b *Index, nodeKey func(Node) interface{}) Delta {
+	delta := Delta{NodeKey: nodeKey}
+	aNodes, aKeys := keyedNodes(a, nodeKey)
+	bNodes, bKeys := keyedNodes(b, nodeKey)
+	for _, key := range aKeys {
+		node := aNodes[LabelKey(key)]
+		other, ok := bNodes[LabelKey(key)]
+		if !ok {
+			delta.RemovedNodes = append(delta.RemovedNodes, NodeDelta{Key: key, Label: node.GetLabel(), Payload: nodePayload(node)})
+			continue
+		}
+		if !LabelsEqual(node.GetLabel(), other.GetLabel()) || !reflect.DeepEqual(nodePayload(node), nodePayload(other)) {
+			delta.ChangedNodes = append(delta.ChangedNodes, NodeDelta{
+				Key:        key,
+				Label:      other.GetLabel(),
+				Payload:    nodePayload(other),
+				OldLabel:   node.GetLabel(),
+				OldPayload: nodePayload(node),
+			})
+		}
+		_, root := a.g.nodes[node]
+		_, otherRoot := b.g.nodes[other]
+		if root && !otherRoot {
+			delta.RemovedRoots = append(delta.RemovedRoots, key)
+		} else if !root && otherRoot {
+			delta.AddedRoots = append(delta.AddedRoots, key)
+		}
+	}
+	for _, key := range bKeys {
+		if _, ok := aNodes[LabelKey(key)]; !ok {
+			node := bNodes[LabelKey(key)]
+			_, root := b.g.nodes[node]
+			delta.AddedNodes = append(delta.AddedNodes, NodeDelta{Key: key, Label: node.GetLabel(), Payload: nodePayload(node), Root: root})
+		}
+	}
+
+	aGroups, aList := groupEdges(aNodes, aKeys, nodeKey)
+	bGroups, bList := groupEdges(bNodes, bKeys, nodeKey)
+	for _, group := range aList {
+		k := [3]interface{}{LabelKey(group.from), LabelKey(group.to), LabelKey(group.label)}
+		var others []Edge
+		if other, ok := bGroups[k]; ok {
+			others = other.edges
+		}
+		removed, changed, added := matchEdges(group.edges, others)
+		for _, edge := range removed {
+			delta.RemovedEdges = append(delta.RemovedEdges, EdgeDelta{From: group.from, To: group.to, Label: group.label, Payload: edgePayload(edge)})
+		}
+		for _, pair := range changed {
+			delta.ChangedEdges = append(delta.ChangedEdges, EdgeDelta{From: group.from, To: group.to, Label: group.label, Payload: edgePayload(pair[1]), OldPayload: edgePayload(pair[0])})
+		}
+		for _, edge := range added {
+			delta.AddedEdges = append(delta.AddedEdges, EdgeDelta{From: group.from, To: group.to, Label: group.label, Payload: edgePayload(edge)})
+		}
+	}
+	for _, group := range bList {
+		k := [3]interface{}{LabelKey(group.from), LabelKey(group.to), LabelKey(group.label)}
+		if _, ok := aGroups[k]; !ok {
+			for _, edge := range group.edges {
+				delta.AddedEdges = append(delta.AddedEdges, EdgeDelta{From: group.from, To: group.to, Label: group.label, Payload: edgePayload(edge)})
+			}
+		}
+	}
+	return delta
+}
+
+// matchEdges matches edges with equal payloads, then pairs the
+// remaining edges in order. Returns the unmatched edges of a, the
+// pairs with different payloads, and the unmatched edges of b.
+func matchEdges(a, b []Edge) (removed []Edge, changed [][2]Edge, added []Edge) {
+	matched := make([]bool, len(b))
+	for _, edge := range a {
+		found := false
+		for j, other := range b {
+			if !matched[j] && reflect.DeepEqual(edgePayload(edge), edgePayload(other)) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			removed = append(removed, edge)
+		}
+	}
+	for j, other := range b {
+		if matched[j] {
+			continue
+		}
+		if len(removed) > 0 {
+			changed = append(changed, [2]Edge{removed[0], other})
+			removed = removed[1:]
+		} else {
+			added = append(added, other)
+		}
+	}
+	return
+}
+
+// findEdge returns an edge from the node with the given target key
+// and label, preferring an edge with the given payload
+func findEdge(from Node, to interface{}, label, payload interface{}, nodeKey func(Node) interface{}) Edge {
+	var found Edge
+	for edges := from.OutWith(label); edges.HasNext(); {
+		edge := edges.Next()
+		if !LabelsEqual(nodeKey(edge.GetTo()), to) {
+			continue
+		}
+		if reflect.DeepEqual(edgePayload(edge), payload) {
+			return edge
+		}
+		if found == nil {
+			found = edge
+		}
+	}
+	return found
+}
+
+// Apply applies the delta to the graph. The graph should be the graph
+// the delta was computed from, or a copy of it. Roots are added
+// first, so nodes that are accessible only through removed edges stay
+// in the graph. Then edges are removed, nodes are removed, added, and
+// changed, edges are changed and added, and finally roots are
+// removed. Nodes and edges are connected using the graph methods, so
+// policies are enforced and listeners are notified.
+// Apply stops at the first error, leaving the graph partially
+// modified.
+func Apply(g *Graph, delta Delta) error {
+	if delta.NodeKey == nil {
+		return fmt.Errorf("%w: no node key function", ErrDeltaConflict)
+	}
+	index := g.GetIndex()
+	nodes, _ := keyedNodes(index, delta.NodeKey)
+	lookup := func(key interface{}) (Node, error) {
+		if node, ok := nodes[LabelKey(key)]; ok {
+			return node, nil
+		}
+		return nil, fmt.Errorf("%w: node %v not found", ErrDeltaConflict, key)
+	}
+	for _, key := range delta.AddedRoots {
+		node, err := lookup(key)
+		if err != nil {
+			return err
+		}
+		if err := g.TryAddNode(node); err != nil {
+			return err
+		}
+	}
+	for _, e := range delta.RemovedEdges {
+		from, err := lookup(e.From)
+		if err != nil {
+			return err
+		}
+		edge := findEdge(from, e.To, e.Label, e.Payload, delta.NodeKey)
+		if edge == nil {
+			return fmt.Errorf("%w: edge %v -[%v]-> %v not found", ErrDeltaConflict, e.From, e.Label, e.To)
+		}
+		g.Disconnect(edge)
+	}
+	// All removed nodes are looked up before any of them is removed,
+	// and their incoming edges are found using a single index
+	removed := make([]Node, 0, len(delta.RemovedNodes))
+	for _, n := range delta.RemovedNodes {
+		node, err := lookup(n.Key)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, node)
+	}
+	for i, node := range removed {
+		g.removeNode(node, index.InSlice(node))
+		delete(nodes, LabelKey(delta.RemovedNodes[i].Key))
+	}
+	for _, n := range delta.AddedNodes {
+		if _, ok := nodes[LabelKey(n.Key)]; ok {
+			return fmt.Errorf("%w: node %v already exists", ErrDeltaConflict, n.Key)
+		}
+		var node Node
+		if delta.NewNode != nil {
+			node = delta.NewNode(n)
+		} else {
+			node = NewBasicNode(n.Label, n.Payload)
+		}
+		if n.Root {
+			if err := g.TryAddNode(node); err != nil {
+				return err
+			}
+		}
+		nodes[LabelKey(n.Key)] = node
+	}
+	for _, n := range delta.ChangedNodes {
+		node, err := lookup(n.Key)
+		if err != nil {
+			return err
+		}
+		if !LabelsEqual(node.GetLabel(), n.Label) {
+			g.SetLabel(node, n.Label)
+		}
+		if basic, ok := node.(*BasicNode); ok {
+			basic.Payload = n.Payload
+		}
+	}
+	for _, e := range delta.ChangedEdges {
+		from, err := lookup(e.From)
+		if err != nil {
+			return err
+		}
+		edge := findEdge(from, e.To, e.Label, e.OldPayload, delta.NodeKey)
+		if edge == nil {
+			return fmt.Errorf("%w: edge %v -[%v]-> %v not found", ErrDeltaConflict, e.From, e.Label, e.To)
+		}
+		if basic, ok := edge.(*BasicEdge); ok {
+			basic.Payload = e.Payload
+		}
+	}
+	for _, e := range delta.AddedEdges {
+		from, err := lookup(e.From)
+		if err != nil {
+			return err
+		}
+		to, err := lookup(e.To)
+		if err != nil {
+			return err
+		}
+		var edge Edge
+		if delta.NewEdge != nil {
+			edge = delta.NewEdge(e)
+		} else {
+			edge = NewBasicEdge(e.Label, e.Payload)
+		}
+		if err := g.Connect(from, to, edge); err != nil {
+			return err
+		}
+	}
+	for _, key := range delta.RemovedRoots {
+		node, err := lookup(key)
+		if err != nil {
+			return err
+		}
+		g.removeRoot(node)
+	}
+	return nil
+}
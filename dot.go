@@ -3,88 +3,259 @@ package digraph
 import (
 	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
 )
 
+// DOTAttributes is a set of Graphviz attributes. Keys are attribute
+// names. Values are written as quoted strings using fmt.Sprint,
+// except DOTHTML values that are written as HTML-like labels.
+// Attributes are written sorted by name.
+type DOTAttributes map[string]interface{}
+
+// DOTHTML is an HTML-like attribute value. It is written in angle
+// brackets without escaping, so it must be a valid HTML-like label.
+type DOTHTML string
+
+var (
+	dotIDRegex      = regexp.MustCompile(`^[a-zA-Z_\x{80}-\x{10FFFF}][a-zA-Z_0-9\x{80}-\x{10FFFF}]*$`)
+	dotNumeralRegex = regexp.MustCompile(`^-?(\.[0-9]+|[0-9]+(\.[0-9]*)?)$`)
+	dotKeywords     = map[string]struct{}{"node": {}, "edge": {}, "graph": {}, "digraph": {}, "subgraph": {}, "strict": {}}
+)
+
+// DOTQuote returns s as a quoted DOT string. Quotes and backslashes
+// are escaped, and newlines are written as \n.
+func DOTQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// DOTID returns s as a DOT ID. If s is a valid unquoted DOT ID or a
+// numeral, it is returned as is. Otherwise it is quoted.
+func DOTID(s string) string {
+	if _, keyword := dotKeywords[strings.ToLower(s)]; !keyword && (dotIDRegex.MatchString(s) || dotNumeralRegex.MatchString(s)) {
+		return s
+	}
+	return DOTQuote(s)
+}
+
+// dotValue returns the DOT representation of an attribute value
+func dotValue(value interface{}) string {
+	if html, ok := value.(DOTHTML); ok {
+		return "<" + string(html) + ">"
+	}
+	return DOTQuote(fmt.Sprint(value))
+}
+
+// String returns the attributes as a DOT attribute list without the
+// brackets
+func (attrs DOTAttributes) String() string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, DOTID(key)+"="+dotValue(attrs[key]))
+	}
+	return strings.Join(items, ", ")
+}
+
+// writeDOTStatement writes a node, edge, or attribute statement with the
+// given attributes
+func writeDOTStatement(w io.Writer, indent, stmt string, attrs DOTAttributes) error {
+	var err error
+	if len(attrs) == 0 {
+		_, err = fmt.Fprintf(w, "%s%s;\n", indent, stmt)
+	} else {
+		_, err = fmt.Fprintf(w, "%s%s [%s];\n", indent, stmt, attrs)
+	}
+	return err
+}
+
 // DOTRenderer renders a graph in Graphviz dot format
 type DOTRenderer struct {
-	// NodeRenderer renderes a node. If the node is to be excluded, returns false.
+	// NodeRenderer renderes a node. If the node is to be excluded,
+	// returns false. If set, NodeAttributes is not used. The renderer
+	// is responsible for escaping the output.
 	NodeRenderer func(string, Node, io.Writer) (bool, error)
 	// EdgeRenderer renders an edge. The from and to nodes are rendered
-	// if this is called. If the edge is to be excluded, returns false
+	// if this is called. If the edge is to be excluded, returns
+	// false. If set, EdgeAttributes is not used. The renderer is
+	// responsible for escaping the output.
 	EdgeRenderer func(fromID string, toID string, edge Edge, w io.Writer) (bool, error)
+	// NodeAttributes returns the attributes of a node. If the node is
+	// to be excluded, returns false. If nil, DefaultDOTNodeAttributes
+	// is used
+	NodeAttributes func(Node) (DOTAttributes, bool)
+	// EdgeAttributes returns the attributes of an edge. If the edge is
+	// to be excluded, returns false. If nil, DefaultDOTEdgeAttributes
+	// is used
+	EdgeAttributes func(Edge) (DOTAttributes, bool)
+	// GraphAttributes are the attributes of the graph. If nil, the
+	// graph is rendered left to right
+	GraphAttributes DOTAttributes
+	// NodeDefaults and EdgeDefaults are the default attributes for all
+	// nodes and edges
+	NodeDefaults DOTAttributes
+	EdgeDefaults DOTAttributes
+	// Cluster, if set, returns the cluster path of a node. A node with
+	// path ["a","b"] is written in cluster b nested in cluster a. Nodes
+	// with empty path are written outside clusters.
+	Cluster func(Node) []string
+	// ClusterAttributes returns the attributes of the cluster with the
+	// given path. If nil, clusters are labeled with their names.
+	ClusterAttributes func(path []string) DOTAttributes
+	// NodeLess, if set, orders the nodes in the output. Otherwise nodes
+	// are written in the order returned by Graph.GetAllNodes
+	NodeLess func(a, b Node) bool
+	// EdgeLess, if set, orders the outgoing edges of a node in the
+	// output. Otherwise edges are written in the order returned by
+	// Node.Out
+	EdgeLess func(a, b Edge) bool
 }
 
-// RenderNode renders a node. If node renderer is not set, calls the default renderer
+// RenderNode renders a node. If node renderer is not set, writes the
+// node with its attributes
 func (d DOTRenderer) RenderNode(ID string, node Node, w io.Writer) (bool, error) {
-	if d.NodeRenderer == nil {
-		return true, DefaultDOTNodeRender(ID, node, w)
+	return d.renderNode("  ", ID, node, w)
+}
+
+func (d DOTRenderer) renderNode(indent, ID string, node Node, w io.Writer) (bool, error) {
+	if d.NodeRenderer != nil {
+		return d.NodeRenderer(ID, node, w)
 	}
-	return d.NodeRenderer(ID, node, w)
+	attrs, ok := d.nodeAttributes(node)
+	if !ok {
+		return false, nil
+	}
+	return true, writeDOTStatement(w, indent, DOTID(ID), attrs)
 }
 
-// RenderEdge renders an edge. If edge renderer is not set, call the default rendeded
+// RenderEdge renders an edge. If edge renderer is not set, writes the
+// edge with its attributes
 func (d DOTRenderer) RenderEdge(fromID, toID string, edge Edge, w io.Writer) (bool, error) {
-	if d.EdgeRenderer == nil {
-		return true, DefaultDOTEdgeRender(fromID, toID, edge, w)
+	if d.EdgeRenderer != nil {
+		return d.EdgeRenderer(fromID, toID, edge, w)
+	}
+	attrs, ok := d.edgeAttributes(edge)
+	if !ok {
+		return false, nil
 	}
-	return d.EdgeRenderer(fromID, toID, edge, w)
+	return true, writeDOTStatement(w, "  ", DOTID(fromID)+" -> "+DOTID(toID), attrs)
+}
+
+func (d DOTRenderer) nodeAttributes(node Node) (DOTAttributes, bool) {
+	if d.NodeAttributes == nil {
+		return DefaultDOTNodeAttributes(node), true
+	}
+	return d.NodeAttributes(node)
+}
+
+func (d DOTRenderer) edgeAttributes(edge Edge) (DOTAttributes, bool) {
+	if d.EdgeAttributes == nil {
+		return DefaultDOTEdgeAttributes(edge), true
+	}
+	return d.EdgeAttributes(edge)
+}
+
+// DefaultDOTNodeAttributes returns the label of the node as the
+// label attribute. If the node does not have a label, returns nil.
+func DefaultDOTNodeAttributes(node Node) DOTAttributes {
+	if lbl := node.GetLabel(); lbl != nil {
+		return DOTAttributes{"label": lbl}
+	}
+	return nil
+}
+
+// DefaultDOTEdgeAttributes returns the label of the edge as the
+// label attribute. If the edge does not have a label, returns nil.
+func DefaultDOTEdgeAttributes(edge Edge) DOTAttributes {
+	if lbl := edge.GetLabel(); lbl != nil {
+		return DOTAttributes{"label": lbl}
+	}
+	return nil
 }
 
 // DefaultDOTNodeRender renders the node with the given ID. If the
 // node has a label, it uses that label, otherwise node is not
 // labeled.
 func DefaultDOTNodeRender(ID string, node Node, w io.Writer) error {
-	if node.GetLabel() != nil {
-		_, err := fmt.Fprintf(w, "  %s [label=\"%v\"];\n", ID, node.GetLabel())
-		return err
-	}
-	_, err := fmt.Fprintf(w, "  %s;\n", ID)
-	return err
+	return writeDOTStatement(w, "  ", DOTID(ID), DefaultDOTNodeAttributes(node))
 }
 
 // DefaultDOTEdgeRender renders the edge with a label if there is
 // one, or without a label if there is not a label.
 func DefaultDOTEdgeRender(fromNode, toNode string, edge Edge, w io.Writer) error {
-	lbl := edge.GetLabel()
-	if lbl != nil {
-		if _, err := fmt.Fprintf(w, "  %s -> %s [label=\"%s\"];\n", fromNode, toNode, lbl); err != nil {
-			return err
-		}
-	} else {
-		if _, err := fmt.Fprintf(w, "  %s -> %s;\n", fromNode, toNode); err != nil {
-			return err
-		}
-	}
-	return nil
+	return writeDOTStatement(w, "  ", DOTID(fromNode)+" -> "+DOTID(toNode), DefaultDOTEdgeAttributes(edge))
 }
 
 // Render writes a DOT graph with the given name
 func (d DOTRenderer) Render(g *Graph, graphName string, out io.Writer) error {
-	if _, err := fmt.Fprintf(out, "digraph %s {\n", graphName); err != nil {
+	if _, err := fmt.Fprintf(out, "digraph %s {\n", DOTID(graphName)); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintf(out, "rankdir=\"LR\";\n"); err != nil {
-		return err
+	graphAttrs := d.GraphAttributes
+	if graphAttrs == nil {
+		graphAttrs = DOTAttributes{"rankdir": "LR"}
 	}
-
-	// Give nodes unique IDs for the graph
-	nodeMap := map[Node]string{}
-	x := 0
-	for itr := g.GetAllNodes(); itr.HasNext(); {
-		node := itr.Next()
-		nodeId := fmt.Sprintf("n%d", x)
-		rendered, err := d.RenderNode(nodeId, node, out)
-		if err != nil {
+	keys := make([]string, 0, len(graphAttrs))
+	for key := range graphAttrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(out, "%s=%s;\n", DOTID(key), dotValue(graphAttrs[key])); err != nil {
 			return err
 		}
-		if rendered {
-			x++
-			nodeMap[node] = nodeId
+	}
+	if len(d.NodeDefaults) > 0 {
+		if err := writeDOTStatement(out, "", "node", d.NodeDefaults); err != nil {
+			return err
+		}
+	}
+	if len(d.EdgeDefaults) > 0 {
+		if err := writeDOTStatement(out, "", "edge", d.EdgeDefaults); err != nil {
+			return err
 		}
 	}
-	for itr := g.GetAllNodes(); itr.HasNext(); {
-		node := itr.Next()
-		for edgeItr := node.Out(); edgeItr.HasNext(); {
+
+	allNodes, ids := renderNodeIDs(g, d.NodeLess)
+	root := &dotCluster{}
+	for _, node := range allNodes {
+		cluster := root
+		if d.Cluster != nil {
+			for _, name := range d.Cluster(node) {
+				cluster = cluster.child(name)
+			}
+		}
+		cluster.nodes = append(cluster.nodes, node)
+	}
+	nodeMap := map[Node]string{}
+	nClusters := 0
+	if err := d.renderCluster(root, "  ", nil, &nClusters, ids, nodeMap, out); err != nil {
+		return err
+	}
+	for _, node := range allNodes {
+		edgeItr := renderOut(node, d.EdgeLess)
+		for edgeItr.HasNext() {
 			edge := edgeItr.Next()
 			fromNodeId, ok1 := nodeMap[edge.GetFrom()]
 			toNodeId, ok2 := nodeMap[edge.GetTo()]
@@ -102,3 +273,100 @@ func (d DOTRenderer) Render(g *Graph, graphName string, out io.Writer) error {
 	}
 	return nil
 }
+
+// dotCluster is a node of the cluster tree. Clusters and nodes are
+// kept in the order they are first seen.
+type dotCluster struct {
+	name     string
+	nodes    []Node
+	children []*dotCluster
+	byName   map[string]*dotCluster
+}
+
+// child returns the child cluster with the given name, creating it if
+// necessary
+func (c *dotCluster) child(name string) *dotCluster {
+	if ch, ok := c.byName[name]; ok {
+		return ch
+	}
+	if c.byName == nil {
+		c.byName = make(map[string]*dotCluster)
+	}
+	ch := &dotCluster{name: name}
+	c.byName[name] = ch
+	c.children = append(c.children, ch)
+	return ch
+}
+
+// renderCluster writes the nodes of the cluster followed by its
+// subclusters. Clusters are named cluster_0, cluster_1, ... in the
+// order they are written. Rendered nodes are recorded in rendered.
+func (d DOTRenderer) renderCluster(c *dotCluster, indent string, path []string, nClusters *int, ids, rendered map[Node]string, out io.Writer) error {
+	for _, node := range c.nodes {
+		ok, err := d.renderNode(indent, ids[node], node, out)
+		if err != nil {
+			return err
+		}
+		if ok {
+			rendered[node] = ids[node]
+		}
+	}
+	for _, ch := range c.children {
+		chPath := append(append([]string{}, path...), ch.name)
+		if _, err := fmt.Fprintf(out, "%ssubgraph cluster_%d {\n", indent, *nClusters); err != nil {
+			return err
+		}
+		*nClusters++
+		var attrs DOTAttributes
+		if d.ClusterAttributes != nil {
+			attrs = d.ClusterAttributes(chPath)
+		} else {
+			attrs = DOTAttributes{"label": ch.name}
+		}
+		if len(attrs) > 0 {
+			if err := writeDOTStatement(out, indent+"  ", "graph", attrs); err != nil {
+				return err
+			}
+		}
+		if err := d.renderCluster(ch, indent+"  ", chPath, nClusters, ids, rendered, out); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "%s}\n", indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderNodeIDs returns the nodes of the graph in output order, and
+// their IDs. Nodes are numbered from 1 in the order of their node
+// IDs, so the output does not depend on the IDs allocated to nodes of
+// other graphs, and nodes created later are numbered after the
+// existing nodes. Nodes without IDs are numbered last, in output
+// order. The graph is not modified.
+func renderNodeIDs(g *Graph, less func(a, b Node) bool) ([]Node, map[Node]string) {
+	nodes := g.GetAllNodes()
+	if less != nil {
+		nodes = nodes.SortedBy(less)
+	}
+	allNodes := nodes.All()
+	byID := append([]Node(nil), allNodes...)
+	sort.SliceStable(byID, func(i, j int) bool {
+		a, b := byID[i].ID(), byID[j].ID()
+		return a != 0 && (b == 0 || a < b)
+	})
+	ids := make(map[Node]string, len(allNodes))
+	for i, node := range byID {
+		ids[node] = fmt.Sprintf("n%d", i+1)
+	}
+	return allNodes, ids
+}
+
+// renderOut returns the outgoing edges of a node in output order
+func renderOut(node Node, less func(a, b Edge) bool) Edges {
+	edges := node.Out()
+	if less != nil {
+		edges = edges.SortedBy(less)
+	}
+	return edges
+}
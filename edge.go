@@ -3,8 +3,7 @@ package digraph
 // Edge represents a labeled or unlabeled directed edge between two
 // nodes of a graph
 type Edge interface {
-	// Return the label of the edge. Label cannot be changed. Remove the
-	// edge and add a new one with a different label
+	// Return the label of the edge. Use Relabel to change the label
 	GetLabel() interface{}
 	// Return the target node
 	GetTo() Node
@@ -22,7 +21,13 @@ type EdgeHeader struct {
 	to    Node
 	from  Node
 	label interface{}
-	edge  Edge
+	// key is the label key computed when the edge is connected or
+	// relabeled. Edge sets index the edge by this key
+	key interface{}
+	// sortKey is computed from key when the edge is added to a sorted
+	// edge set, so lookups do not format labels
+	sortKey *sortKey
+	edge    Edge
 }
 
 // NewEdgeHeader returns a new constructed edge header with the given
@@ -35,41 +40,154 @@ func (hdr *EdgeHeader) getEdgeHeader() *EdgeHeader {
 	return hdr
 }
 
-// GetLabel returns the edge label. Once set, label cannot be changed
+// GetLabel returns the edge label. Use Relabel to change the label
 func (hdr *EdgeHeader) GetLabel() interface{} {
 	return hdr.label
 }
 
-// GetTo returns the target node of the edge
+// GetTo returns the target node of the edge, or nil if the edge is
+// not connected
 func (hdr *EdgeHeader) GetTo() Node {
+	if hdr.edge == nil {
+		return nil
+	}
 	return hdr.to
 }
 
-// GetFrom returns the source node of the edge
+// GetFrom returns the source node of the edge, or nil if the edge is
+// not connected
 func (hdr *EdgeHeader) GetFrom() Node {
+	if hdr.edge == nil {
+		return nil
+	}
 	return hdr.from
 }
 
 // Disconnect an edge
 func (hdr *EdgeHeader) Disconnect() {
-	if hdr.from != nil && hdr.edge != nil {
-		hdr.from.removeOutgoingEdge(hdr.edge)
+	if hdr.from == nil || hdr.edge == nil {
+		return
 	}
+	edge, from, to := hdr.edge, hdr.from, hdr.to
+	from.removeOutgoingEdge(edge)
 	hdr.edge = nil
 	hdr.from = nil
 	hdr.to = nil
+	if g := from.getNodeHeader().graph; g != nil {
+		if g.closed {
+			g.edgeCount--
+		}
+		g.notify(Event{Type: EdgeDisconnected, Edge: edge, From: from, To: to})
+	}
 }
 
-// Connect two nodes with the given edge. The edge must not be connected before
+// Connect two nodes with the given edge. The edge must not be
+// connected before. Panics if the nodes cannot be connected.
 func Connect(from, to Node, edge Edge) {
+	if err := TryConnect(from, to, edge); err != nil {
+		panic(err)
+	}
+}
+
+// TryConnect connects two nodes with the given edge. Returns
+// ErrNilNode or ErrNilEdge for nil arguments, ErrEdgeConnected if the
+// edge is already connected, and ErrForeignNode if the nodes belong
+// to different graphs and one of them is closed, or if from does not
+// belong to a graph and to belongs to a closed graph.
+func TryConnect(from, to Node, edge Edge) error {
+	if from == nil || to == nil {
+		return ErrNilNode
+	}
+	if edge == nil {
+		return ErrNilEdge
+	}
 	hdr := edge.getEdgeHeader()
 	if hdr.edge != nil {
-		panic("Edge is already connected")
+		return ErrEdgeConnected
+	}
+	g := from.getNodeHeader().graph
+	if g != nil {
+		if err := g.checkForeign(to); err != nil {
+			return err
+		}
+	} else if g.isForeign(to) {
+		// A node that does not belong to a graph cannot be connected
+		// to a node of a closed graph
+		return ErrForeignNode
 	}
 	hdr.edge = edge
 	hdr.to = to
 	hdr.from = from
+	hdr.key = LabelKey(hdr.label)
+	hdr.sortKey = nil
 	from.addOutgoingEdge(edge)
+	if g != nil {
+		g.own(to)
+		g.edgeAdded(from, to)
+		if g.closed {
+			g.edgeCount++
+		}
+		g.notify(Event{Type: EdgeConnected, Edge: edge, From: from, To: to})
+	}
+	return nil
+}
+
+// Relabel changes the label of an edge. The edge keeps its position
+// among the outgoing edges of its source node. Indexes built before
+// the edge is relabeled are not updated, use Index.Relabel to keep an
+// index up to date. Snapshots taken before the edge is relabeled keep
+// the old label.
+func Relabel(edge Edge, label interface{}) {
+	hdr := edge.getEdgeHeader()
+	if hdr.edge != nil {
+		hdr.from.getNodeHeader().modified()
+	}
+	old, oldKey := hdr.label, hdr.key
+	hdr.label = label
+	hdr.key = LabelKey(label)
+	hdr.sortKey = nil
+	if hdr.edge == nil {
+		return
+	}
+	hdr.from.relabelOutgoingEdge(edge, oldKey)
+	hdr.from.getNodeHeader().graph.notify(Event{Type: EdgeRelabeled, Edge: edge, From: hdr.from, To: hdr.to, OldLabel: old, NewLabel: label})
+}
+
+// edgeKey returns the label key of an edge. For connected edges, this
+// is the key computed when the edge is connected or relabeled
+func edgeKey(edge Edge) interface{} {
+	hdr := edge.getEdgeHeader()
+	if hdr.edge == nil {
+		return LabelKey(hdr.label)
+	}
+	return hdr.key
+}
+
+// EdgesBetween returns the edges from the from node to the to node.
+// This is O(1) for nodes with many edges.
+func EdgesBetween(from, to Node) Edges {
+	return from.OutTo(to)
+}
+
+// GetEdge returns the first edge from the from node to the to node
+// with the given label, or nil if there is none. If label is
+// AnyLabel, returns the first edge with any label.
+func GetEdge(from, to Node, label interface{}) Edge {
+	key := LabelKey(label)
+	for edges := from.OutTo(to); edges.HasNext(); {
+		edge := edges.Next()
+		if label == AnyLabel || edgeKey(edge) == key {
+			return edge
+		}
+	}
+	return nil
+}
+
+// HasEdge returns true if there is an edge from the from node to the
+// to node with the given label. If label is AnyLabel, returns true if
+// there is an edge with any label.
+func HasEdge(from, to Node, label interface{}) bool {
+	return GetEdge(from, to, label) != nil
 }
 
 // BasicEdge contains an application-defined payload
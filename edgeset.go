@@ -1,5 +1,9 @@
 package digraph
 
+import (
+	"sort"
+)
+
 // Most nodes have few edges. For those nodes, a slice is a better
 // storage than a map. The edgeSet interface allows usings both slices
 // and maps as needed
@@ -12,6 +16,74 @@ type edgeSet interface {
 	length() int
 	next() []Node
 	nextWith(interface{}) []Node
+	// edges returns the edges in iteration order. The returned slice
+	// must not be modified
+	edges() []Edge
+	// edgesTo returns the edges to the given node. The returned slice
+	// must not be modified
+	edgesTo(Node) []Edge
+	// relabelEdge updates the set after the label key of e is
+	// changed from oldKey
+	relabelEdge(e Edge, oldKey interface{})
+}
+
+// newEdgeSet returns a new edge set for the storage containing the
+// given edges
+func newEdgeSet(storage EdgeStorage, edges []Edge) edgeSet {
+	var set edgeSet
+	switch storage {
+	case SliceStorage:
+		set = newSliceEdgeSet()
+	case LabelMapStorage:
+		set = newMapEdgeSet()
+	case SortedStorage:
+		set = newSortedEdgeSet()
+	case TargetMapStorage:
+		set = newTargetEdgeSet()
+	default:
+		if len(edges) > adaptiveThreshold {
+			set = newTargetEdgeSet()
+		} else {
+			set = newSliceEdgeSet()
+		}
+	}
+	for _, edge := range edges {
+		set.addEdge(edge)
+	}
+	return set
+}
+
+// uniqueTargets returns the unique targets of edges, skipping nil
+// edges. If selectLabel is set, only the edges whose label key is key
+// are included
+func uniqueTargets(edges []Edge, selectLabel bool, key interface{}) []Node {
+	ret := make([]Node, 0, len(edges))
+	seen := make(map[Node]struct{})
+	for _, e := range edges {
+		if e == nil {
+			continue
+		}
+		if selectLabel && edgeKey(e) != key {
+			continue
+		}
+		to := e.GetTo()
+		if _, ok := seen[to]; !ok {
+			ret = append(ret, to)
+			seen[to] = struct{}{}
+		}
+	}
+	return ret
+}
+
+// edgesTo returns the edges to the given node, skipping nil edges
+func edgesTo(edges []Edge, to Node) []Edge {
+	var ret []Edge
+	for _, e := range edges {
+		if e != nil && e.GetTo() == to {
+			ret = append(ret, e)
+		}
+	}
+	return ret
 }
 
 type sliceEdgeSet []Edge
@@ -42,18 +114,16 @@ func (set *sliceEdgeSet) removeEdge(e Edge) {
 
 func (set sliceEdgeSet) getEdges() Edges { return Edges{&edgeSliceIterator{Edges: set}} }
 
+func (set sliceEdgeSet) edges() []Edge { return set }
+
+func (set sliceEdgeSet) edgesTo(to Node) []Edge { return edgesTo(set, to) }
+
 func (set sliceEdgeSet) getEdgesWith(label interface{}) Edges {
-	return Edges{&edgeSliceIterator{Edges: set}}.Select(func(e Edge) bool { return e.GetLabel() == label })
+	key := LabelKey(label)
+	return Edges{&edgeSliceIterator{Edges: set}}.Select(func(e Edge) bool { return edgeKey(e) == key })
 }
 
-func (set sliceEdgeSet) toMap() *mapEdgeSet {
-	ret := newMapEdgeSet()
-	ret.s = set
-	for _, edge := range set {
-		ret.m[edge.GetLabel()] = append(ret.m[edge.GetLabel()], edge)
-	}
-	return ret
-}
+func (set sliceEdgeSet) relabelEdge(Edge, interface{}) {}
 
 func (set sliceEdgeSet) next() []Node {
 	switch len(set) {
@@ -62,16 +132,7 @@ func (set sliceEdgeSet) next() []Node {
 	case 1:
 		return []Node{set[0].GetTo()}
 	default:
-		ret := make([]Node, 0, len(set))
-		seen := make(map[Node]struct{})
-		for _, e := range set {
-			to := e.GetTo()
-			if _, ok := seen[to]; !ok {
-				ret = append(ret, to)
-				seen[to] = struct{}{}
-			}
-		}
-		return ret
+		return uniqueTargets(set, false, nil)
 	}
 }
 
@@ -80,76 +141,318 @@ func (set sliceEdgeSet) nextWith(label interface{}) []Node {
 	case 0:
 		return nil
 	case 1:
-		if set[0].GetLabel() == label {
+		if edgeKey(set[0]) == LabelKey(label) {
 			return []Node{set[0].GetTo()}
 		}
 		return nil
 	default:
-		ret := make([]Node, 0, len(set))
-		seen := make(map[Node]struct{})
-		for _, e := range set {
-			if e.GetLabel() == label {
-				to := e.GetTo()
-				if _, ok := seen[to]; !ok {
-					ret = append(ret, to)
-					seen[to] = struct{}{}
-				}
-			}
+		return uniqueTargets(set, true, LabelKey(label))
+	}
+}
+
+// orderedEdges keeps edges in insertion order, with amortized O(1)
+// removal. Removed edges leave nil holes in the slice, which is
+// compacted when more than half of it is holes.
+type orderedEdges struct {
+	s     []Edge
+	pos   map[Edge]int
+	holes int
+}
+
+func newOrderedEdges() *orderedEdges {
+	return &orderedEdges{pos: make(map[Edge]int)}
+}
+
+func (o *orderedEdges) add(e Edge) {
+	o.pos[e] = len(o.s)
+	o.s = append(o.s, e)
+}
+
+func (o *orderedEdges) remove(e Edge) {
+	i, ok := o.pos[e]
+	if !ok {
+		return
+	}
+	delete(o.pos, e)
+	o.s[i] = nil
+	o.holes++
+	if o.holes > len(o.s)/2 {
+		o.compact()
+	}
+}
+
+func (o *orderedEdges) compact() {
+	s := make([]Edge, 0, len(o.pos))
+	for _, e := range o.s {
+		if e != nil {
+			o.pos[e] = len(s)
+			s = append(s, e)
 		}
-		return ret
 	}
+	o.s = s
+	o.holes = 0
 }
 
+func (o *orderedEdges) length() int { return len(o.pos) }
+
+// edges returns the edges without holes
+func (o *orderedEdges) edges() []Edge {
+	if o.holes == 0 {
+		return o.s
+	}
+	ret := make([]Edge, 0, len(o.pos))
+	for _, e := range o.s {
+		if e != nil {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func (o *orderedEdges) iterator() Edges {
+	if o.holes == 0 {
+		return Edges{&edgeSliceIterator{Edges: o.s}}
+	}
+	return Edges{&edgeHoleIterator{edges: o.s}}
+}
+
+// edgeHoleIterator iterates a slice of edges skipping nils
+type edgeHoleIterator struct {
+	edges []Edge
+}
+
+func (e *edgeHoleIterator) HasNext() bool {
+	for len(e.edges) > 0 && e.edges[0] == nil {
+		e.edges = e.edges[1:]
+	}
+	return len(e.edges) > 0
+}
+
+func (e *edgeHoleIterator) Next() Edge {
+	if !e.HasNext() {
+		panic("Next edge not available")
+	}
+	ret := e.edges[0]
+	e.edges = e.edges[1:]
+	return ret
+}
+
+// mapEdgeSet keeps the edges in insertion order for ordered access,
+// and in a map keyed by the label key for lookup by label
 type mapEdgeSet struct {
-	m map[interface{}]sliceEdgeSet
-	s sliceEdgeSet
+	m map[interface{}]*orderedEdges
+	s *orderedEdges
 }
 
 func newMapEdgeSet() *mapEdgeSet {
-	return &mapEdgeSet{m: make(map[interface{}]sliceEdgeSet)}
+	return &mapEdgeSet{m: make(map[interface{}]*orderedEdges), s: newOrderedEdges()}
 }
 
 func (set *mapEdgeSet) addEdge(e Edge) {
-	label := e.GetLabel()
-	set.m[label] = append(set.m[label], e)
-	set.s.addEdge(e)
+	key := edgeKey(e)
+	x := set.m[key]
+	if x == nil {
+		x = newOrderedEdges()
+		set.m[key] = x
+	}
+	x.add(e)
+	set.s.add(e)
 }
 
-func (set mapEdgeSet) hasEdges() bool { return len(set.s) > 0 }
+func (set *mapEdgeSet) hasEdges() bool { return set.s.length() > 0 }
 
 func (set *mapEdgeSet) removeEdge(e Edge) {
-	label := e.GetLabel()
-	x := set.m[label]
+	key := edgeKey(e)
+	x := set.m[key]
 	if x == nil {
 		return
 	}
-	x.removeEdge(e)
-	if len(x) == 0 {
-		delete(set.m, label)
-	} else {
-		set.m[label] = x
+	x.remove(e)
+	if x.length() == 0 {
+		delete(set.m, key)
+	}
+	set.s.remove(e)
+}
+
+func (set *mapEdgeSet) relabelEdge(e Edge, oldKey interface{}) {
+	if x := set.m[oldKey]; x != nil {
+		x.remove(e)
+		if x.length() == 0 {
+			delete(set.m, oldKey)
+		}
+	}
+	// Rebuild the edges with the new label to keep them in the same
+	// order as they are in s
+	key := edgeKey(e)
+	x := newOrderedEdges()
+	for _, edge := range set.s.s {
+		if edge != nil && edgeKey(edge) == key {
+			x.add(edge)
+		}
 	}
-	set.s.removeEdge(e)
+	set.m[key] = x
 }
 
-func (set mapEdgeSet) getEdgesWith(label interface{}) Edges {
-	return set.m[label].getEdges()
+func (set *mapEdgeSet) getEdgesWith(label interface{}) Edges {
+	x := set.m[LabelKey(label)]
+	if x == nil {
+		return Edges{&edgeSliceIterator{}}
+	}
+	return x.iterator()
 }
 
-func (set mapEdgeSet) getEdges() Edges {
-	return Edges{&edgeSliceIterator{Edges: set.s}}
+func (set *mapEdgeSet) getEdges() Edges {
+	return set.s.iterator()
 }
 
-func (set mapEdgeSet) length() int { return len(set.s) }
+func (set *mapEdgeSet) length() int { return set.s.length() }
+
+func (set *mapEdgeSet) edges() []Edge { return set.s.edges() }
 
-func (set mapEdgeSet) next() []Node {
-	return set.s.next()
+func (set *mapEdgeSet) edgesTo(to Node) []Edge { return edgesTo(set.s.s, to) }
+
+func (set *mapEdgeSet) next() []Node {
+	return uniqueTargets(set.s.s, false, nil)
 }
 
-func (set mapEdgeSet) nextWith(label interface{}) []Node {
-	m := set.m[label]
-	if m == nil {
+func (set *mapEdgeSet) nextWith(label interface{}) []Node {
+	x := set.m[LabelKey(label)]
+	if x == nil {
 		return nil
 	}
-	return m.nextWith(label)
+	return uniqueTargets(x.s, false, nil)
+}
+
+// targetEdgeSet is a mapEdgeSet that also indexes edges by their
+// target node
+type targetEdgeSet struct {
+	mapEdgeSet
+	t map[Node][]Edge
+}
+
+func newTargetEdgeSet() *targetEdgeSet {
+	return &targetEdgeSet{mapEdgeSet: *newMapEdgeSet(), t: make(map[Node][]Edge)}
+}
+
+func (set *targetEdgeSet) addEdge(e Edge) {
+	set.mapEdgeSet.addEdge(e)
+	to := e.GetTo()
+	set.t[to] = append(set.t[to], e)
+}
+
+func (set *targetEdgeSet) removeEdge(e Edge) {
+	set.mapEdgeSet.removeEdge(e)
+	to := e.GetTo()
+	x := set.t[to]
+	w := 0
+	for _, edge := range x {
+		if edge != e {
+			x[w] = edge
+			w++
+		}
+	}
+	if w == 0 {
+		delete(set.t, to)
+	} else {
+		set.t[to] = x[:w]
+	}
+}
+
+func (set *targetEdgeSet) edgesTo(to Node) []Edge { return set.t[to] }
+
+func (set *targetEdgeSet) next() []Node {
+	ret := make([]Node, 0, len(set.t))
+	for _, e := range set.s.s {
+		if e == nil {
+			continue
+		}
+		// Add a target when its first edge is seen to keep the order
+		if to := e.GetTo(); set.t[to][0] == e {
+			ret = append(ret, to)
+		}
+	}
+	return ret
+}
+
+// sortedEdgeSet keeps the edges sorted by their labels. Edges with the
+// same label are kept in insertion order.
+type sortedEdgeSet []Edge
+
+func newSortedEdgeSet() *sortedEdgeSet { return &sortedEdgeSet{} }
+
+// labelRange returns the range of edges with the given sort key
+func (set sortedEdgeSet) labelRange(key *sortKey) (int, int) {
+	lo := sort.Search(len(set), func(i int) bool { return compareSortKeys(set[i].getEdgeHeader().sortKey, key) >= 0 })
+	hi := sort.Search(len(set), func(i int) bool { return compareSortKeys(set[i].getEdgeHeader().sortKey, key) > 0 })
+	return lo, hi
+}
+
+// withLabel returns the edges with the given label
+func (set sortedEdgeSet) withLabel(label interface{}) []Edge {
+	key := LabelKey(label)
+	lo, hi := set.labelRange(newSortKey(key))
+	ret := make([]Edge, 0, hi-lo)
+	for _, e := range set[lo:hi] {
+		// Different keys may compare equal
+		if edgeKey(e) == key {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func (set *sortedEdgeSet) addEdge(e Edge) {
+	hdr := e.getEdgeHeader()
+	if hdr.sortKey == nil {
+		hdr.sortKey = newSortKey(hdr.key)
+	}
+	_, hi := set.labelRange(hdr.sortKey)
+	*set = append(*set, nil)
+	copy((*set)[hi+1:], (*set)[hi:])
+	(*set)[hi] = e
+}
+
+func (set sortedEdgeSet) hasEdges() bool { return len(set) > 0 }
+
+func (set *sortedEdgeSet) removeEdge(e Edge) {
+	lo, hi := set.labelRange(e.getEdgeHeader().sortKey)
+	for i := lo; i < hi; i++ {
+		if (*set)[i] == e {
+			copy((*set)[i:], (*set)[i+1:])
+			(*set)[len(*set)-1] = nil
+			*set = (*set)[:len(*set)-1]
+			return
+		}
+	}
+}
+
+func (set sortedEdgeSet) getEdges() Edges { return Edges{&edgeSliceIterator{Edges: set}} }
+
+func (set sortedEdgeSet) getEdgesWith(label interface{}) Edges {
+	return Edges{&edgeSliceIterator{Edges: set.withLabel(label)}}
+}
+
+func (set sortedEdgeSet) length() int { return len(set) }
+
+func (set sortedEdgeSet) next() []Node { return uniqueTargets(set, false, nil) }
+
+func (set sortedEdgeSet) nextWith(label interface{}) []Node {
+	return uniqueTargets(set.withLabel(label), false, nil)
+}
+
+func (set sortedEdgeSet) edges() []Edge { return set }
+
+func (set sortedEdgeSet) edgesTo(to Node) []Edge { return edgesTo(set, to) }
+
+func (set *sortedEdgeSet) relabelEdge(e Edge, oldKey interface{}) {
+	// The set is not sorted until the edge is moved to the position
+	// of the new label, so search linearly
+	for i := range *set {
+		if (*set)[i] == e {
+			copy((*set)[i:], (*set)[i+1:])
+			*set = (*set)[:len(*set)-1]
+			break
+		}
+	}
+	set.addEdge(e)
 }
@@ -0,0 +1,150 @@
+package digraph
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+var allStorages = []EdgeStorage{AdaptiveStorage, SliceStorage, LabelMapStorage, SortedStorage, TargetMapStorage}
+
+func TestEdgeStorages(t *testing.T) {
+	for _, storage := range allStorages {
+		t.Run(storage.String(), func(t *testing.T) {
+			root := NewBasicNode("root", nil)
+			root.SetEdgeStorage(storage)
+			targets := []*BasicNode{NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil)}
+			edges := make([]*BasicEdge, 0)
+			for i := 0; i < 30; i++ {
+				edge := NewBasicEdge(i%4, nil)
+				Connect(root, targets[i%3], edge)
+				edges = append(edges, edge)
+			}
+			if n := len(root.Out().All()); n != 30 {
+				t.Errorf("Expected 30 edges, got %d", n)
+			}
+			if n := len(root.OutWith(1).All()); n != 8 {
+				t.Errorf("Expected 8 edges with label, got %d", n)
+			}
+			if n := len(root.Next()); n != 3 {
+				t.Errorf("Expected 3 next nodes, got %d", n)
+			}
+			for i := 0; i < 24; i++ {
+				edges[i].Disconnect()
+			}
+			out := root.Out().All()
+			if len(out) != 6 {
+				t.Errorf("Expected 6 edges, got %d", len(out))
+			}
+			for _, edge := range out {
+				if edge.GetFrom() != root {
+					t.Errorf("Removed edge returned")
+				}
+			}
+			if n := len(root.OutWith(1).All()); n != 2 {
+				t.Errorf("Expected 2 edges with label, got %d", n)
+			}
+			if n := len(root.NextWith(0)); n != 2 {
+				t.Errorf("Expected 2 next nodes with label, got %d", n)
+			}
+			if n := len(root.out.edgesTo(targets[0])); n != 2 {
+				t.Errorf("Expected 2 edges to target, got %d", n)
+			}
+			Relabel(edges[24], "x")
+			if x := root.OutWith("x").All(); len(x) != 1 || x[0] != edges[24] {
+				t.Errorf("Relabel failed")
+			}
+			edges[25].Disconnect()
+			edges[26].Disconnect()
+			if n := len(root.Out().All()); n != 4 {
+				t.Errorf("Expected 4 edges, got %d", n)
+			}
+			if storage == AdaptiveStorage {
+				if _, ok := root.out.(*sliceEdgeSet); !ok {
+					t.Errorf("Adaptive storage not demoted")
+				}
+			}
+		})
+	}
+}
+
+func TestSortedLabels(t *testing.T) {
+	root := NewBasicNode("root", nil)
+	root.SetEdgeStorage(SortedStorage)
+	labels := []interface{}{1.0, math.NaN(), "b", 2.0, nil, math.NaN(), []string{"x"}, 0.5, "a", 2.0}
+	edges := make([]*BasicEdge, 0)
+	for _, label := range labels {
+		edge := NewBasicEdge(label, nil)
+		Connect(root, NewBasicNode(nil, nil), edge)
+		edges = append(edges, edge)
+	}
+	set := *root.out.(*sortedEdgeSet)
+	for i := 1; i < len(set); i++ {
+		if compareSortKeys(set[i-1].getEdgeHeader().sortKey, set[i].getEdgeHeader().sortKey) > 0 {
+			t.Errorf("Edges not sorted at %d: %v %v", i, set[i-1].GetLabel(), set[i].GetLabel())
+		}
+	}
+	if n := len(root.OutWith(2.0).All()); n != 2 {
+		t.Errorf("Expected 2 edges, got %d", n)
+	}
+	if n := len(root.OutWith([]string{"x"}).All()); n != 1 {
+		t.Errorf("Expected 1 edge, got %d", n)
+	}
+	edges[1].Disconnect()
+	edges[5].Disconnect()
+	if n := len(root.Out().All()); n != len(labels)-2 {
+		t.Errorf("NaN edges not removed: %d edges", n)
+	}
+}
+
+func buildHub(storage EdgeStorage, n int) (*BasicNode, []*BasicNode, []*BasicEdge) {
+	root := NewBasicNode("root", nil)
+	root.SetEdgeStorage(storage)
+	targets := make([]*BasicNode, 0, n)
+	edges := make([]*BasicEdge, 0, n)
+	for i := 0; i < n; i++ {
+		target := NewBasicNode(i, nil)
+		edge := NewBasicEdge(i%16, nil)
+		Connect(root, target, edge)
+		targets = append(targets, target)
+		edges = append(edges, edge)
+	}
+	return root, targets, edges
+}
+
+func BenchmarkEdgeStorage(b *testing.B) {
+	for _, storage := range allStorages {
+		for _, n := range []int{8, 1000} {
+			name := fmt.Sprintf("%s/%d", storage, n)
+			b.Run(name+"/Add", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					buildHub(storage, n)
+				}
+			})
+			b.Run(name+"/RemoveAll", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					_, _, edges := buildHub(storage, n)
+					b.StartTimer()
+					for _, edge := range edges {
+						edge.Disconnect()
+					}
+				}
+			})
+			b.Run(name+"/OutWith", func(b *testing.B) {
+				root, _, _ := buildHub(storage, n)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					root.OutWith(i % 16).All()
+				}
+			})
+			b.Run(name+"/EdgesTo", func(b *testing.B) {
+				root, targets, _ := buildHub(storage, n)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					root.out.edgesTo(targets[i%n])
+				}
+			})
+		}
+	}
+}
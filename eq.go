@@ -55,7 +55,7 @@ func CheckIsomorphism(nodes1, nodes2 *Index, nodeEquivalenceFunc func(n1, n2 Nod
 		for _, edge1 := range edges1 {
 			found := false
 			for _, edge2 := range edges2 {
-				if edge1.GetLabel() == edge2.GetLabel() &&
+				if LabelsEqual(edge1.GetLabel(), edge2.GetLabel()) &&
 					nodeMapping1_2[edge1.GetTo()] == edge2.GetTo() &&
 					edgeEquivalenceFunc(edge1, edge2) {
 					if found {
@@ -0,0 +1,23 @@
+package digraph
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNilNode is returned when a nil node is passed where a node is
+	// required
+	ErrNilNode = errors.New("nil node")
+	// ErrNilEdge is returned when a nil edge is passed where an edge is
+	// required
+	ErrNilEdge = errors.New("nil edge")
+	// ErrEdgeConnected is returned when an edge that is already
+	// connected is connected again
+	ErrEdgeConnected = errors.New("edge is already connected")
+	// ErrEdgeNotConnected is returned when an edge that is not
+	// connected is used where a connected edge is required
+	ErrEdgeNotConnected = errors.New("edge is not connected")
+	// ErrSelfLoopForbidden is returned when an edge from a node to
+	// itself is rejected
+	ErrSelfLoopForbidden = errors.New("self loops are forbidden")
+)
@@ -0,0 +1,166 @@
+package digraph
+
+// EventType is the type of a graph modification event
+type EventType int
+
+const (
+	// NodeAdded is emitted when a node is added to the graph using
+	// AddNode
+	NodeAdded EventType = iota
+	// NodeRemoved is emitted when a node is removed from the graph
+	NodeRemoved
+	// EdgeConnected is emitted when an edge is connected
+	EdgeConnected
+	// EdgeDisconnected is emitted when an edge is disconnected
+	EdgeDisconnected
+	// NodeLabelChanged is emitted when the label of a node changes
+	NodeLabelChanged
+	// EdgeRelabeled is emitted when the label of an edge changes
+	EdgeRelabeled
+)
+
+func (t EventType) String() string {
+	switch t {
+	case NodeAdded:
+		return "NodeAdded"
+	case NodeRemoved:
+		return "NodeRemoved"
+	case EdgeConnected:
+		return "EdgeConnected"
+	case EdgeDisconnected:
+		return "EdgeDisconnected"
+	case NodeLabelChanged:
+		return "NodeLabelChanged"
+	case EdgeRelabeled:
+		return "EdgeRelabeled"
+	}
+	return "Unknown"
+}
+
+// Event describes a modification of a graph
+type Event struct {
+	Type EventType
+	// Node is set for node events
+	Node Node
+	// Edge, From, and To are set for edge events. For
+	// EdgeDisconnected, From and To are the nodes the edge was
+	// connected to
+	Edge Edge
+	From Node
+	To   Node
+	// OldLabel and NewLabel are set for label change events
+	OldLabel interface{}
+	NewLabel interface{}
+}
+
+// Listener is called synchronously when a graph is modified
+type Listener func(Event)
+
+// ListenerID identifies a registered listener
+type ListenerID int
+
+type registeredListener struct {
+	id       ListenerID
+	listener Listener
+}
+
+// AddListener registers a listener that is called for every
+// modification of the graph. Returns an ID that can be used to remove
+// the listener.
+//
+// A node belongs to the first graph it is added to, or connected
+// through using Graph.Connect. Nodes connected to a node of a graph
+// using Connect also become part of that graph, and so do the nodes
+// accessible from them. A node removed using RemoveNode no longer
+// belongs to the graph. Modifications of nodes and edges are reported
+// to the graph the node, or the source node of the edge belongs to,
+// whether they are made through the Graph methods or directly.
+func (g *Graph) AddListener(listener Listener) ListenerID {
+	g.lastListenerID++
+	g.listeners = append(g.listeners, registeredListener{id: g.lastListenerID, listener: listener})
+	return g.lastListenerID
+}
+
+// RemoveListener removes a registered listener
+func (g *Graph) RemoveListener(id ListenerID) {
+	for i, l := range g.listeners {
+		if l.id == id {
+			g.listeners = append(g.listeners[:i:i], g.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify calls all listeners with the event. g can be nil
+func (g *Graph) notify(event Event) {
+	if g == nil {
+		return
+	}
+	for _, l := range g.listeners {
+		l.listener(event)
+	}
+}
+
+// own makes g the owner of node if node does not belong to a graph,
+// assigns an ID to the node, and applies the edge storage of the graph
+// to the node. The nodes accessible from node that do not belong to a
+// graph also become nodes of g. The nodes accessible from a node of g
+// always belong to a graph, so the traversal stops at the nodes that
+// belong to a graph.
+func (g *Graph) own(node Node) {
+	if node.getNodeHeader().graph != nil {
+		g.adopt(node)
+		return
+	}
+	g.adopt(node)
+	stack := []Node{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range n.Next() {
+			if next.getNodeHeader().graph == nil {
+				g.adopt(next)
+				stack = append(stack, next)
+			}
+		}
+	}
+}
+
+// adopt makes g the owner of a single node if it does not belong to a
+// graph, assigns an ID to it, and applies the edge storage of the
+// graph
+func (g *Graph) adopt(node Node) {
+	g.register(node)
+	hdr := node.getNodeHeader()
+	if hdr.graph == nil {
+		hdr.view = nil
+		hdr.graph = g
+		hdr.self = node
+	}
+	if g.closed && hdr.graph == g {
+		if _, ok := g.nodes[node]; !ok {
+			g.init()
+			g.rootsModified()
+			g.nodes[node] = struct{}{}
+			g.order = append(g.order, node)
+			if hdr.out != nil {
+				g.edgeCount += hdr.out.length()
+			}
+			g.notify(Event{Type: NodeAdded, Node: node})
+		}
+	}
+	if hdr.storage == AdaptiveStorage && g.edgeStorage != AdaptiveStorage {
+		hdr.SetEdgeStorage(g.edgeStorage)
+	}
+}
+
+// disown removes node from g. The node no longer reports its
+// modifications to g
+func (g *Graph) disown(node Node) {
+	hdr := node.getNodeHeader()
+	if hdr.graph != g {
+		return
+	}
+	hdr.modified()
+	hdr.graph = nil
+}
@@ -0,0 +1,487 @@
+package digraph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attributes are the application-defined attributes of a node or an
+// edge, such as values taken from payloads
+type Attributes map[string]interface{}
+
+// sortedKeys returns the keys of the attributes in sorted order
+func (attrs Attributes) sortedKeys() []string {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// exportCallbacks are the node and edge callbacks common to all
+// exporters
+type exportCallbacks struct {
+	nodeLabel func(Node) string
+	edgeLabel func(Edge) string
+	nodeAttrs func(Node) Attributes
+	edgeAttrs func(Edge) Attributes
+}
+
+func (c exportCallbacks) node(node Node) (string, Attributes) {
+	label := ""
+	if c.nodeLabel != nil {
+		label = c.nodeLabel(node)
+	} else if lbl := node.GetLabel(); lbl != nil {
+		label = fmt.Sprint(lbl)
+	}
+	var attrs Attributes
+	if c.nodeAttrs != nil {
+		attrs = c.nodeAttrs(node)
+	}
+	return label, attrs
+}
+
+func (c exportCallbacks) edge(edge Edge) (string, Attributes) {
+	label := ""
+	if c.edgeLabel != nil {
+		label = c.edgeLabel(edge)
+	} else if lbl := edge.GetLabel(); lbl != nil {
+		label = fmt.Sprint(lbl)
+	}
+	var attrs Attributes
+	if c.edgeAttrs != nil {
+		attrs = c.edgeAttrs(edge)
+	}
+	return label, attrs
+}
+
+// exportEdges calls f for all edges between the nodes in output order
+func exportEdges(nodes []Node, ids map[Node]string, f func(edge Edge, from, to string) error) error {
+	for _, node := range nodes {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			to, ok := ids[edge.GetTo()]
+			if !ok {
+				continue
+			}
+			if err := f(edge, ids[node], to); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GEXFSpell is the value of a dynamic attribute in a time interval.
+// An empty Start or End means the interval is unbounded on that
+// side. A GEXF attribute with a []GEXFSpell value is written as a
+// dynamic attribute.
+type GEXFSpell struct {
+	Value      interface{}
+	Start, End string
+}
+
+// GEXFExporter writes a graph in the GEXF 1.3 format used by Gephi
+type GEXFExporter struct {
+	// NodeLabel returns the label of a node. If nil, the node label is
+	// printed using fmt.Sprint
+	NodeLabel func(Node) string
+	// EdgeLabel returns the label of an edge. If nil, the edge label is
+	// printed using fmt.Sprint
+	EdgeLabel func(Edge) string
+	// NodeAttributes returns the attributes of a node
+	NodeAttributes func(Node) Attributes
+	// EdgeAttributes returns the attributes of an edge
+	EdgeAttributes func(Edge) Attributes
+	// NodeInterval returns the time interval the node exists. If set,
+	// the graph is written as a dynamic graph
+	NodeInterval func(Node) (start, end string)
+	// EdgeInterval returns the time interval the edge exists. If set,
+	// the graph is written as a dynamic graph
+	EdgeInterval func(Edge) (start, end string)
+	// TimeFormat is the format of the time values of a dynamic graph,
+	// one of integer, double, date, or dateTime. Default is double
+	TimeFormat string
+}
+
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Mode            string           `xml:"mode,attr"`
+	TimeFormat      string           `xml:"timeformat,attr,omitempty"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Mode       string          `xml:"mode,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+	Start string `xml:"start,attr,omitempty"`
+	End   string `xml:"end,attr,omitempty"`
+}
+
+type gexfAttValues struct {
+	Values []gexfAttValue `xml:"attvalue"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr,omitempty"`
+	Start     string         `xml:"start,attr,omitempty"`
+	End       string         `xml:"end,attr,omitempty"`
+	AttValues *gexfAttValues `xml:"attvalues"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Label     string         `xml:"label,attr,omitempty"`
+	Start     string         `xml:"start,attr,omitempty"`
+	End       string         `xml:"end,attr,omitempty"`
+	AttValues *gexfAttValues `xml:"attvalues"`
+}
+
+// gexfType returns the GEXF type of a value
+func gexfType(value interface{}) string {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "long"
+	case reflect.Float32, reflect.Float64:
+		return "double"
+	}
+	return "string"
+}
+
+// gexfAttributeSet collects the attribute declarations of a class
+type gexfAttributeSet struct {
+	types   map[string]string
+	dynamic bool
+}
+
+func (s *gexfAttributeSet) add(attrs Attributes) {
+	for key, value := range attrs {
+		typ := ""
+		if spells, ok := value.([]GEXFSpell); ok {
+			s.dynamic = true
+			for _, spell := range spells {
+				if t := gexfType(spell.Value); typ == "" || typ == t {
+					typ = t
+				} else {
+					typ = "string"
+				}
+			}
+		} else {
+			typ = gexfType(value)
+		}
+		if existing, ok := s.types[key]; ok && existing != typ {
+			typ = "string"
+		}
+		s.types[key] = typ
+	}
+}
+
+// declare returns the attribute declarations, and the IDs of the
+// attributes
+func (s *gexfAttributeSet) declare(class string) (gexfAttributes, map[string]string) {
+	keys := make([]string, 0, len(s.types))
+	for key := range s.types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ret := gexfAttributes{Class: class, Mode: "static"}
+	if s.dynamic {
+		ret.Mode = "dynamic"
+	}
+	ids := make(map[string]string)
+	for i, key := range keys {
+		ids[key] = strconv.Itoa(i)
+		ret.Attributes = append(ret.Attributes, gexfAttribute{ID: ids[key], Title: key, Type: s.types[key]})
+	}
+	return ret, ids
+}
+
+func gexfValues(attrs Attributes, ids map[string]string) *gexfAttValues {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := &gexfAttValues{}
+	for _, key := range attrs.sortedKeys() {
+		if spells, ok := attrs[key].([]GEXFSpell); ok {
+			for _, spell := range spells {
+				ret.Values = append(ret.Values, gexfAttValue{For: ids[key], Value: fmt.Sprint(spell.Value), Start: spell.Start, End: spell.End})
+			}
+		} else {
+			ret.Values = append(ret.Values, gexfAttValue{For: ids[key], Value: fmt.Sprint(attrs[key])})
+		}
+	}
+	return ret
+}
+
+// Export writes the graph as a GEXF document
+func (x GEXFExporter) Export(g *Graph, out io.Writer) error {
+	cb := exportCallbacks{nodeLabel: x.NodeLabel, edgeLabel: x.EdgeLabel, nodeAttrs: x.NodeAttributes, edgeAttrs: x.EdgeAttributes}
+	allNodes, ids := renderNodeIDs(g, nil)
+	doc := gexfDocument{XMLNS: "http://gexf.net/1.3", Version: "1.3", Graph: gexfGraph{DefaultEdgeType: "directed", Mode: "static"}}
+	nodeAttrs := make([]Attributes, 0, len(allNodes))
+	nodeSet := &gexfAttributeSet{types: make(map[string]string)}
+	for _, node := range allNodes {
+		label, attrs := cb.node(node)
+		nodeSet.add(attrs)
+		nodeAttrs = append(nodeAttrs, attrs)
+		n := gexfNode{ID: ids[node], Label: label}
+		if x.NodeInterval != nil {
+			n.Start, n.End = x.NodeInterval(node)
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+	edgeAttrs := make([]Attributes, 0)
+	edgeSet := &gexfAttributeSet{types: make(map[string]string)}
+	exportEdges(allNodes, ids, func(edge Edge, from, to string) error {
+		label, attrs := cb.edge(edge)
+		edgeSet.add(attrs)
+		edgeAttrs = append(edgeAttrs, attrs)
+		e := gexfEdge{ID: fmt.Sprintf("e%d", len(doc.Graph.Edges)), Source: from, Target: to, Label: label}
+		if x.EdgeInterval != nil {
+			e.Start, e.End = x.EdgeInterval(edge)
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, e)
+		return nil
+	})
+	nodeDecl, nodeIDs := nodeSet.declare("node")
+	edgeDecl, edgeIDs := edgeSet.declare("edge")
+	for i := range doc.Graph.Nodes {
+		doc.Graph.Nodes[i].AttValues = gexfValues(nodeAttrs[i], nodeIDs)
+	}
+	for i := range doc.Graph.Edges {
+		doc.Graph.Edges[i].AttValues = gexfValues(edgeAttrs[i], edgeIDs)
+	}
+	if len(nodeDecl.Attributes) > 0 {
+		doc.Graph.Attributes = append(doc.Graph.Attributes, nodeDecl)
+	}
+	if len(edgeDecl.Attributes) > 0 {
+		doc.Graph.Attributes = append(doc.Graph.Attributes, edgeDecl)
+	}
+	if x.NodeInterval != nil || x.EdgeInterval != nil || nodeSet.dynamic || edgeSet.dynamic {
+		doc.Graph.Mode = "dynamic"
+		doc.Graph.TimeFormat = x.TimeFormat
+		if doc.Graph.TimeFormat == "" {
+			doc.Graph.TimeFormat = "double"
+		}
+	}
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// GMLExporter writes a graph in the Graph Modelling Language
+type GMLExporter struct {
+	// NodeLabel returns the label of a node. If nil, the node label is
+	// printed using fmt.Sprint
+	NodeLabel func(Node) string
+	// EdgeLabel returns the label of an edge. If nil, the edge label is
+	// printed using fmt.Sprint
+	EdgeLabel func(Edge) string
+	// NodeAttributes returns the attributes of a node. Attribute values
+	// that are Attributes are written as nested lists. Characters that
+	// are not valid in GML keys are replaced by _
+	NodeAttributes func(Node) Attributes
+	// EdgeAttributes returns the attributes of an edge
+	EdgeAttributes func(Edge) Attributes
+}
+
+// GMLQuote returns s as a quoted GML string. Characters that are not
+// allowed in GML strings are written as HTML entities.
+func GMLQuote(s string) string {
+	return `"` + strings.NewReplacer("&", "&amp;", `"`, "&quot;").Replace(s) + `"`
+}
+
+// gmlKey returns key as a valid GML key
+func gmlKey(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+func writeGMLAttributes(w *errWriter, indent string, attrs Attributes) {
+	for _, key := range attrs.sortedKeys() {
+		value := attrs[key]
+		if nested, ok := value.(Attributes); ok {
+			w.printf("%s%s [\n", indent, gmlKey(key))
+			writeGMLAttributes(w, indent+"  ", nested)
+			w.printf("%s]\n", indent)
+			continue
+		}
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Bool:
+			if v.Bool() {
+				w.printf("%s%s 1\n", indent, gmlKey(key))
+			} else {
+				w.printf("%s%s 0\n", indent, gmlKey(key))
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			w.printf("%s%s %d\n", indent, gmlKey(key), value)
+		case reflect.Float32, reflect.Float64:
+			s := strconv.FormatFloat(v.Float(), 'g', -1, 64)
+			if !strings.ContainsAny(s, ".eEn") {
+				s += ".0"
+			}
+			w.printf("%s%s %s\n", indent, gmlKey(key), s)
+		default:
+			w.printf("%s%s %s\n", indent, gmlKey(key), GMLQuote(fmt.Sprint(value)))
+		}
+	}
+}
+
+// Export writes the graph as a GML document. Nodes are numbered from
+// 1 in the order of their node IDs.
+func (x GMLExporter) Export(g *Graph, out io.Writer) error {
+	cb := exportCallbacks{nodeLabel: x.NodeLabel, edgeLabel: x.EdgeLabel, nodeAttrs: x.NodeAttributes, edgeAttrs: x.EdgeAttributes}
+	allNodes, ids := renderNodeIDs(g, nil)
+	numbers := make(map[Node]string, len(allNodes))
+	for _, node := range allNodes {
+		numbers[node] = strings.TrimPrefix(ids[node], "n")
+	}
+	w := &errWriter{w: out}
+	w.printf("graph [\n  directed 1\n")
+	for _, node := range allNodes {
+		label, attrs := cb.node(node)
+		w.printf("  node [\n    id %s\n", numbers[node])
+		if label != "" {
+			w.printf("    label %s\n", GMLQuote(label))
+		}
+		writeGMLAttributes(w, "    ", attrs)
+		w.printf("  ]\n")
+	}
+	exportEdges(allNodes, ids, func(edge Edge, _, _ string) error {
+		label, attrs := cb.edge(edge)
+		w.printf("  edge [\n    source %s\n    target %s\n", numbers[edge.GetFrom()], numbers[edge.GetTo()])
+		if label != "" {
+			w.printf("    label %s\n", GMLQuote(label))
+		}
+		writeGMLAttributes(w, "    ", attrs)
+		w.printf("  ]\n")
+		return w.err
+	})
+	w.printf("]\n")
+	return w.err
+}
+
+// CytoscapeExporter writes a graph as Cytoscape.js elements JSON
+type CytoscapeExporter struct {
+	// NodeLabel returns the label of a node. If nil, the node label is
+	// printed using fmt.Sprint
+	NodeLabel func(Node) string
+	// EdgeLabel returns the label of an edge. If nil, the edge label is
+	// printed using fmt.Sprint
+	EdgeLabel func(Edge) string
+	// NodeAttributes returns the data fields of a node. The id and
+	// label fields are reserved
+	NodeAttributes func(Node) Attributes
+	// EdgeAttributes returns the data fields of an edge. The id,
+	// source, target, and label fields are reserved
+	EdgeAttributes func(Edge) Attributes
+	// Layout, if set, is used to write node positions
+	Layout *Layout
+}
+
+type cytoscapeElement struct {
+	Data     map[string]interface{} `json:"data"`
+	Position *Point                 `json:"position,omitempty"`
+}
+
+type cytoscapeElements struct {
+	Nodes []cytoscapeElement `json:"nodes"`
+	Edges []cytoscapeElement `json:"edges"`
+}
+
+// Export writes the graph as a JSON object with an elements field
+// containing the nodes and edges
+func (x CytoscapeExporter) Export(g *Graph, out io.Writer) error {
+	cb := exportCallbacks{nodeLabel: x.NodeLabel, edgeLabel: x.EdgeLabel, nodeAttrs: x.NodeAttributes, edgeAttrs: x.EdgeAttributes}
+	allNodes, ids := renderNodeIDs(g, nil)
+	elements := cytoscapeElements{Nodes: []cytoscapeElement{}, Edges: []cytoscapeElement{}}
+	for _, node := range allNodes {
+		label, attrs := cb.node(node)
+		data := make(map[string]interface{}, len(attrs)+2)
+		for k, v := range attrs {
+			data[k] = v
+		}
+		data["id"] = ids[node]
+		if label != "" {
+			data["label"] = label
+		}
+		element := cytoscapeElement{Data: data}
+		if x.Layout != nil {
+			if l, ok := x.Layout.NodeLayout(node); ok {
+				element.Position = &Point{X: l.X, Y: l.Y}
+			}
+		}
+		elements.Nodes = append(elements.Nodes, element)
+	}
+	exportEdges(allNodes, ids, func(edge Edge, from, to string) error {
+		label, attrs := cb.edge(edge)
+		data := make(map[string]interface{}, len(attrs)+4)
+		for k, v := range attrs {
+			data[k] = v
+		}
+		data["id"] = fmt.Sprintf("e%d", len(elements.Edges))
+		data["source"] = from
+		data["target"] = to
+		if label != "" {
+			data["label"] = label
+		}
+		elements.Edges = append(elements.Edges, cytoscapeElement{Data: data})
+		return nil
+	})
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Elements cytoscapeElements `json:"elements"`
+	}{elements})
+}
@@ -0,0 +1,446 @@
+package digraph
+
+import (
+	"sync/atomic"
+)
+
+// FrozenGraph is an immutable compressed sparse row representation of
+// a graph. Nodes and edges are identified by dense integer IDs, so
+// algorithms can use slices and bitsets instead of maps. Node IDs are
+// in [0,NumNodes()), and edge IDs are in [0,NumEdges()). The outgoing
+// edges of node n have the IDs [Offset(n),Offset(n+1)).
+//
+// Labels are stored in a dictionary, and nodes and edges refer to
+// their labels by label ID.
+type FrozenGraph struct {
+	nodes      []Node
+	ids        map[Node]int32
+	nodeLabels []int32
+
+	// Forward CSR: the edges of node n are offsets[n]..offsets[n+1]
+	offsets    []int
+	targets    []int32
+	edgeLabels []int32
+	edges      []Edge
+
+	// Reverse CSR: the incoming edges of node n are
+	// inOffsets[n]..inOffsets[n+1]. inEdges are the edge IDs
+	inOffsets []int
+	sources   []int32
+	inEdges   []int32
+
+	labels   []interface{}
+	labelIDs map[interface{}]int32
+}
+
+// Freeze builds a frozen graph from the nodes and edges of the
+// index. Node IDs are assigned in the order of index.NodesSlice. The
+// frozen graph keeps references to the original nodes and edges, but
+// does not reflect later modifications of the graph.
+func Freeze(index *Index) *FrozenGraph {
+	nodes := index.NodesSlice()
+	f := &FrozenGraph{
+		nodes:      make([]Node, len(nodes)),
+		ids:        make(map[Node]int32, len(nodes)),
+		nodeLabels: make([]int32, len(nodes)),
+		offsets:    make([]int, len(nodes)+1),
+		labelIDs:   make(map[interface{}]int32),
+	}
+	copy(f.nodes, nodes)
+	for i, node := range nodes {
+		f.ids[node] = int32(i)
+		f.nodeLabels[i] = f.labelID(node.GetLabel())
+	}
+	for i, node := range nodes {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			to, ok := f.ids[edge.GetTo()]
+			if !ok {
+				continue
+			}
+			f.targets = append(f.targets, to)
+			f.edgeLabels = append(f.edgeLabels, f.labelID(edge.GetLabel()))
+			f.edges = append(f.edges, edge)
+		}
+		f.offsets[i+1] = len(f.targets)
+	}
+
+	// Build the reverse CSR using counting sort on targets
+	n := len(nodes)
+	f.inOffsets = make([]int, n+1)
+	for _, to := range f.targets {
+		f.inOffsets[to+1]++
+	}
+	for i := 0; i < n; i++ {
+		f.inOffsets[i+1] += f.inOffsets[i]
+	}
+	f.sources = make([]int32, len(f.targets))
+	f.inEdges = make([]int32, len(f.targets))
+	pos := make([]int, n)
+	copy(pos, f.inOffsets[:n])
+	for from := 0; from < n; from++ {
+		for e := f.offsets[from]; e < f.offsets[from+1]; e++ {
+			to := f.targets[e]
+			f.sources[pos[to]] = int32(from)
+			f.inEdges[pos[to]] = int32(e)
+			pos[to]++
+		}
+	}
+	return f
+}
+
+// labelID returns the ID of a label, adding it to the dictionary if
+// necessary
+func (f *FrozenGraph) labelID(label interface{}) int32 {
+	key := LabelKey(label)
+	if id, ok := f.labelIDs[key]; ok {
+		return id
+	}
+	id := int32(len(f.labels))
+	f.labels = append(f.labels, label)
+	f.labelIDs[key] = id
+	return id
+}
+
+// NumNodes returns the number of nodes
+func (f *FrozenGraph) NumNodes() int { return len(f.nodes) }
+
+// NumEdges returns the number of edges
+func (f *FrozenGraph) NumEdges() int { return len(f.targets) }
+
+// Node returns the node with the given ID
+func (f *FrozenGraph) Node(id int) Node { return f.nodes[id] }
+
+// NodeID returns the ID of a node
+func (f *FrozenGraph) NodeID(node Node) (int, bool) {
+	id, ok := f.ids[node]
+	return int(id), ok
+}
+
+// Edge returns the edge with the given ID
+func (f *FrozenGraph) Edge(id int) Edge { return f.edges[id] }
+
+// NumLabels returns the number of distinct node and edge labels
+func (f *FrozenGraph) NumLabels() int { return len(f.labels) }
+
+// Label returns the label with the given label ID
+func (f *FrozenGraph) Label(labelID int) interface{} { return f.labels[labelID] }
+
+// LabelID returns the ID of a label
+func (f *FrozenGraph) LabelID(label interface{}) (int, bool) {
+	id, ok := f.labelIDs[LabelKey(label)]
+	return int(id), ok
+}
+
+// NodeLabelID returns the label ID of a node
+func (f *FrozenGraph) NodeLabelID(id int) int { return int(f.nodeLabels[id]) }
+
+// EdgeLabelID returns the label ID of an edge
+func (f *FrozenGraph) EdgeLabelID(edge int) int { return int(f.edgeLabels[edge]) }
+
+// Offset returns the ID of the first outgoing edge of the node. The
+// outgoing edges of node n are [Offset(n),Offset(n+1)). Offset(NumNodes())
+// is NumEdges().
+func (f *FrozenGraph) Offset(id int) int { return f.offsets[id] }
+
+// Target returns the target node ID of an edge
+func (f *FrozenGraph) Target(edge int) int { return int(f.targets[edge]) }
+
+// Out returns the target node IDs of the outgoing edges of a
+// node. The returned slice must not be modified.
+func (f *FrozenGraph) Out(id int) []int32 {
+	return f.targets[f.offsets[id]:f.offsets[id+1]]
+}
+
+// OutDegree returns the number of outgoing edges of a node
+func (f *FrozenGraph) OutDegree(id int) int {
+	return f.offsets[id+1] - f.offsets[id]
+}
+
+// In returns the source node IDs of the incoming edges of a node. The
+// returned slice must not be modified.
+func (f *FrozenGraph) In(id int) []int32 {
+	return f.sources[f.inOffsets[id]:f.inOffsets[id+1]]
+}
+
+// InEdges returns the edge IDs of the incoming edges of a node. The
+// returned slice must not be modified.
+func (f *FrozenGraph) InEdges(id int) []int32 {
+	return f.inEdges[f.inOffsets[id]:f.inOffsets[id+1]]
+}
+
+// InDegree returns the number of incoming edges of a node
+func (f *FrozenGraph) InDegree(id int) int {
+	return f.inOffsets[id+1] - f.inOffsets[id]
+}
+
+// NodesByLabel returns the IDs of the nodes with the given label
+func (f *FrozenGraph) NodesByLabel(label interface{}) []int {
+	ret := make([]int, 0)
+	labelID, ok := f.labelIDs[LabelKey(label)]
+	if !ok {
+		return ret
+	}
+	for id, l := range f.nodeLabels {
+		if l == labelID {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Sinks returns the IDs of all nodes that have no outgoing edges
+func (f *FrozenGraph) Sinks() []int {
+	ret := make([]int, 0)
+	for id := range f.nodes {
+		if f.OutDegree(id) == 0 {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Sources returns the IDs of all nodes that have no incoming edges
+func (f *FrozenGraph) Sources() []int {
+	ret := make([]int, 0)
+	for id := range f.nodes {
+		if f.InDegree(id) == 0 {
+			ret = append(ret, id)
+		}
+	}
+	return ret
+}
+
+// Iterate performs a depth-first traversal of the nodes and edges
+// accessible from root until one of the functions returns false. The
+// outgoing edges of a node are followed in the order they had in the
+// source graph when it was frozen. Node and edge IDs are passed to
+// the functions
+func (f *FrozenGraph) Iterate(root int, nodeFunc func(int) bool, edgeFunc func(int) bool) bool {
+	return f.IterateUnique(root, nodeFunc, edgeFunc, NewBitset(len(f.nodes)))
+}
+
+// IterateAll iterates all nodes and edges of the graph until one of
+// the functions returns false
+func (f *FrozenGraph) IterateAll(nodeFunc func(int) bool, edgeFunc func(int) bool) bool {
+	seen := NewBitset(len(f.nodes))
+	for id := range f.nodes {
+		if !f.IterateUnique(id, nodeFunc, edgeFunc, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// IterateUnique iterates all nodes and edges accessible from root
+// until one of the functions returns false. It skips the nodes in
+// the seen set, and adds the visited nodes to it.
+func (f *FrozenGraph) IterateUnique(root int, nodeFunc func(int) bool, edgeFunc func(int) bool, seen Bitset) bool {
+	if seen.Test(root) {
+		return true
+	}
+	seen.Set(root)
+	if !nodeFunc(root) {
+		return false
+	}
+	// Explicit stack of (node, next edge) to avoid deep recursion
+	type frame struct {
+		node int
+		edge int
+	}
+	stack := []frame{{node: root, edge: f.offsets[root]}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.edge == f.offsets[top.node+1] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		edge := top.edge
+		top.edge++
+		if !edgeFunc(edge) {
+			return false
+		}
+		to := int(f.targets[edge])
+		if seen.Test(to) {
+			continue
+		}
+		seen.Set(to)
+		if !nodeFunc(to) {
+			return false
+		}
+		stack = append(stack, frame{node: to, edge: f.offsets[to]})
+	}
+	return true
+}
+
+// Reachable returns true if target can be reached from source
+func (f *FrozenGraph) Reachable(source, target int) bool {
+	found := false
+	f.Iterate(source, func(id int) bool {
+		found = id == target
+		return !found
+	}, func(int) bool { return true })
+	return found
+}
+
+// BFS performs a breadth-first search starting at roots. visit is
+// called for every reachable node with its level, the roots being at
+// level 0. The search stops if visit returns false.
+func (f *FrozenGraph) BFS(roots []int, visit func(id int, level int) bool) {
+	seen := NewBitset(len(f.nodes))
+	frontier := make([]int32, 0, len(roots))
+	for _, root := range roots {
+		if !seen.Test(root) {
+			seen.Set(root)
+			frontier = append(frontier, int32(root))
+		}
+	}
+	for level := 0; len(frontier) > 0; level++ {
+		next := make([]int32, 0, len(frontier))
+		for _, id := range frontier {
+			if !visit(int(id), level) {
+				return
+			}
+			for _, to := range f.Out(int(id)) {
+				if !seen.Test(int(to)) {
+					seen.Set(int(to))
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+}
+
+// ParallelBFS performs a level-synchronous breadth-first search
+// starting at roots using the given number of goroutines. If workers
+// is not positive, GOMAXPROCS goroutines are used. visit is called
+// concurrently for the nodes of the same level. If visit returns
+// false, the search stops after the current level.
+func (f *FrozenGraph) ParallelBFS(roots []int, workers int, visit func(id int, level int) bool) {
+	workers = numWorkers(workers)
+	seen := NewBitset(len(f.nodes))
+	frontier := make([]int32, 0, len(roots))
+	for _, root := range roots {
+		if seen.testAndSet(root) {
+			frontier = append(frontier, int32(root))
+		}
+	}
+	for level := 0; len(frontier) > 0; level++ {
+		var stop int32
+		next := make([][]int32, workers)
+		parallelFor(len(frontier), workers, func(w, lo, hi int) {
+			for _, id := range frontier[lo:hi] {
+				if !visit(int(id), level) {
+					atomic.StoreInt32(&stop, 1)
+				}
+				for _, to := range f.Out(int(id)) {
+					if seen.testAndSet(int(to)) {
+						next[w] = append(next[w], to)
+					}
+				}
+			}
+		})
+		if stop != 0 {
+			return
+		}
+		frontier = frontier[:0:0]
+		for _, n := range next {
+			frontier = append(frontier, n...)
+		}
+	}
+}
+
+// PageRank computes the PageRank of all nodes using the given damping
+// factor (usually 0.85) and number of iterations. Each iteration is
+// computed in parallel using the given number of goroutines. If
+// workers is not positive, GOMAXPROCS goroutines are used. Parallel
+// edges contribute multiple times. The rank of nodes without
+// outgoing edges is distributed evenly to all nodes. Returns the
+// ranks indexed by node ID.
+func (f *FrozenGraph) PageRank(damping float64, iterations int, workers int) []float64 {
+	workers = numWorkers(workers)
+	n := len(f.nodes)
+	rank := make([]float64, n)
+	if n == 0 {
+		return rank
+	}
+	next := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	for iteration := 0; iteration < iterations; iteration++ {
+		dangling := 0.0
+		for i, r := range rank {
+			if f.OutDegree(i) == 0 {
+				dangling += r
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+		// Pull-based computation: every node sums the contributions of
+		// its sources, so each worker writes only to its own chunk of
+		// ranks
+		parallelFor(n, workers, func(_, lo, hi int) {
+			for i := lo; i < hi; i++ {
+				sum := 0.0
+				for _, src := range f.In(i) {
+					sum += rank[src] / float64(f.OutDegree(int(src)))
+				}
+				next[i] = base + damping*sum
+			}
+		})
+		rank, next = next, rank
+	}
+	return rank
+}
+
+// CheckIsomorphism checks if two frozen graphs are equal as defined
+// by the node and edge equivalence functions, the same way
+// CheckIsomorphism does for indexes.
+func (f *FrozenGraph) CheckIsomorphism(other *FrozenGraph, nodeEquivalenceFunc func(n1, n2 Node) bool, edgeEquivalenceFunc func(e1, e2 Edge) bool) bool {
+	if f.NumNodes() != other.NumNodes() || f.NumEdges() != other.NumEdges() {
+		return false
+	}
+	mapping := make([]int32, f.NumNodes())
+	mapped := NewBitset(other.NumNodes())
+	for id := range f.nodes {
+		mapping[id] = -1
+		for _, candidate := range other.NodesByLabel(f.labels[f.nodeLabels[id]]) {
+			if !nodeEquivalenceFunc(f.nodes[id], other.nodes[candidate]) {
+				continue
+			}
+			if mapping[id] != -1 || mapped.Test(candidate) {
+				return false
+			}
+			mapping[id] = int32(candidate)
+			mapped.Set(candidate)
+		}
+		if mapping[id] == -1 {
+			return false
+		}
+	}
+	for id := range f.nodes {
+		otherID := int(mapping[id])
+		if f.OutDegree(id) != other.OutDegree(otherID) {
+			return false
+		}
+		for e1 := f.offsets[id]; e1 < f.offsets[id+1]; e1++ {
+			found := false
+			label := LabelKey(f.labels[f.edgeLabels[e1]])
+			for e2 := other.offsets[otherID]; e2 < other.offsets[otherID+1]; e2++ {
+				if mapping[f.targets[e1]] == other.targets[e2] &&
+					LabelKey(other.labels[other.edgeLabels[e2]]) == label &&
+					edgeEquivalenceFunc(f.edges[e1], other.edges[e2]) {
+					if found {
+						return false
+					}
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
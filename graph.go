@@ -9,10 +9,37 @@ package digraph
 //
 // Two graphs can be merged simply by adding an edge between their two
 // nodes. Then the graph containing the source node of the edge will
-// include all the accessible nodes of the second graph.
+// include all the accessible nodes of the second graph. A closed graph
+// created by NewClosed knows all its nodes, and cannot be merged.
 type Graph struct {
 	// nodes keeps some of the nodes of the graph
 	nodes map[Node]struct{}
+	// order keeps the nodes in insertion order, so the graph is
+	// traversed in a deterministic order
+	order []Node
+
+	// policy is enforced when edges are added using Connect
+	policy Policy
+	// topo is the topological order of the graph used to detect
+	// cycles. It is built by the first acyclic policy check
+	topo *topoOrder
+	// snaps keeps the snapshots of the graph
+	snaps *snapshots
+
+	listeners      []registeredListener
+	lastListenerID ListenerID
+
+	// edgeStorage is the default edge storage of the nodes of the
+	// graph
+	edgeStorage EdgeStorage
+
+	// byID keeps the nodes of the graph that are assigned IDs
+	byID map[uint64]Node
+
+	// closed is set if the graph keeps track of all its nodes. Then
+	// nodes contains all nodes of the graph
+	closed    bool
+	edgeCount int
 }
 
 func (g *Graph) init() {
@@ -28,21 +55,185 @@ func New() *Graph {
 	return g
 }
 
+// NewWithPolicy returns a new empty graph that enforces the given
+// policy
+func NewWithPolicy(policy Policy) *Graph {
+	g := New()
+	g.policy = policy
+	return g
+}
+
+// SetPolicy sets the policy enforced by Connect. Existing edges are
+// not checked.
+func (g *Graph) SetPolicy(policy Policy) {
+	g.policy = policy
+	g.topo = nil
+}
+
+// GetPolicy returns the policy of the graph
+func (g *Graph) GetPolicy() Policy {
+	return g.policy
+}
+
 // AddNode adds the node to the graph. The node can be the node of a
-// disconnected graph.
+// disconnected graph. Panics if node is nil.
 func (g *Graph) AddNode(node Node) {
+	if err := g.TryAddNode(node); err != nil {
+		panic(err)
+	}
+}
+
+// TryAddNode adds the node to the graph. Returns ErrNilNode if node is
+// nil, ErrDuplicateID if the graph has a different node with the same
+// ID, and ErrForeignNode if the node cannot be added to a closed
+// graph.
+func (g *Graph) TryAddNode(node Node) error {
 	if node == nil {
-		panic("nil node")
+		return ErrNilNode
 	}
 	g.init()
+	if _, exists := g.nodes[node]; exists {
+		return nil
+	}
+	if err := g.checkForeign(node); err != nil {
+		return err
+	}
+	if existing, ok := g.byID[node.ID()]; ok && existing != node {
+		return ErrDuplicateID
+	}
+	if g.closed {
+		// The node does not belong to a graph, so it becomes a member
+		// of the graph with the nodes accessible from it
+		g.own(node)
+		return nil
+	}
+	g.rootsModified()
 	g.nodes[node] = struct{}{}
+	g.order = append(g.order, node)
+	g.own(node)
+	g.notify(Event{Type: NodeAdded, Node: node})
+	return nil
 }
 
-// GetAllNodes returns an iterator over all nodes of a graph
+// removeRoot removes node from the nodes known by the graph
+func (g *Graph) removeRoot(node Node) {
+	if _, exists := g.nodes[node]; !exists {
+		return
+	}
+	g.rootsModified()
+	delete(g.nodes, node)
+	for i, n := range g.order {
+		if n == node {
+			g.order = append(g.order[:i:i], g.order[i+1:]...)
+			break
+		}
+	}
+	g.unregister(node)
+	if g.closed {
+		g.disown(node)
+	}
+	g.notify(Event{Type: NodeRemoved, Node: node})
+}
+
+// roots returns the nodes known by the graph in insertion
+// order. Other nodes of the graph are reachable from these nodes
+func (g *Graph) roots() []Node {
+	arr := make([]Node, len(g.order))
+	copy(arr, g.order)
+	return arr
+}
+
+// GetAllNodes returns an iterator over all nodes of a graph. Nodes
+// are returned in breadth-first order starting from the nodes added
+// to the graph, in the order they are added.
 func (g *Graph) GetAllNodes() Nodes {
-	arr := make([]Node, 0, len(g.nodes))
-	for node := range g.nodes {
-		arr = append(arr, node)
+	return NewNodeWalkIterator(g.roots()...)
+}
+
+// Connect connects two nodes with the given edge after checking the
+// graph policy. Returns an error if the edge cannot be connected, or
+// if it violates the policy.
+func (g *Graph) Connect(from, to Node, edge Edge) error {
+	if from == nil || to == nil {
+		return ErrNilNode
+	}
+	if edge == nil {
+		return ErrNilEdge
+	}
+	if edge.getEdgeHeader().edge != nil {
+		return ErrEdgeConnected
+	}
+	if err := g.checkForeign(from); err != nil {
+		return err
+	}
+	if err := g.checkForeign(to); err != nil {
+		return err
+	}
+	if err := g.policy.check(g, from, to, edge); err != nil {
+		return err
+	}
+	g.own(from)
+	g.own(to)
+	return TryConnect(from, to, edge)
+}
+
+// Disconnect removes the edge from the graph. Returns ErrNilEdge if
+// edge is nil
+func (g *Graph) Disconnect(edge Edge) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	edge.Disconnect()
+	return nil
+}
+
+// SetLabel sets the label of a node
+func (g *Graph) SetLabel(node Node, label interface{}) {
+	node.SetLabel(label)
+}
+
+// Relabel changes the label of an edge, keeping its position among
+// the outgoing edges of its source node. Returns ErrNilEdge if edge is
+// nil
+func (g *Graph) Relabel(edge Edge, label interface{}) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	Relabel(edge, label)
+	return nil
+}
+
+// RemoveNode removes the node from the graph by disconnecting all its
+// outgoing edges, and all incoming edges from the nodes accessible in
+// the graph. Finding the incoming edges requires a traversal of the
+// graph. Returns the disconnected edges.
+func (g *Graph) RemoveNode(node Node) []Edge {
+	return g.removeNode(node, g.GetIndex().InSlice(node))
+}
+
+// removeNode removes the node from the graph by disconnecting all its
+// outgoing edges, and the given incoming edges. Incoming edges that
+// are already disconnected are skipped, so the incoming edges of
+// several nodes can be found using a single index.
+func (g *Graph) removeNode(node Node, in []Edge) []Edge {
+	removed := node.Out().All()
+	for _, edge := range in {
+		if from := edge.GetFrom(); from != nil && from != node {
+			removed = append(removed, edge)
+		}
+	}
+	for _, edge := range removed {
+		edge.Disconnect()
+	}
+	if g.topo != nil {
+		g.topo.remove(node)
+	}
+	if _, exists := g.nodes[node]; exists {
+		g.removeRoot(node)
+	} else {
+		g.unregister(node)
+		g.notify(Event{Type: NodeRemoved, Node: node})
 	}
-	return NewNodeWalkIterator(arr...)
+	g.disown(node)
+	return removed
 }
@@ -1,6 +1,15 @@
 package digraph
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -25,3 +34,1590 @@ func TestBasicGraph(t *testing.T) {
 		t.Error("There are still edges")
 	}
 }
+
+func TestTryVariants(t *testing.T) {
+	g := New()
+	if err := g.TryAddNode(nil); err != ErrNilNode {
+		t.Errorf("Expected ErrNilNode, got %v", err)
+	}
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	edge := NewBasicEdge("label", nil)
+	if err := TryConnect(n1, nil, edge); err != ErrNilNode {
+		t.Errorf("Expected ErrNilNode, got %v", err)
+	}
+	if err := TryConnect(n1, n2, edge); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := TryConnect(n2, n1, edge); err != ErrEdgeConnected {
+		t.Errorf("Expected ErrEdgeConnected, got %v", err)
+	}
+
+	edges := n1.Out()
+	if e, ok := edges.NextOK(); !ok || e != edge {
+		t.Errorf("Expected edge")
+	}
+	if _, ok := edges.NextOK(); ok {
+		t.Errorf("Expected end of edges")
+	}
+	nodes := NewNodeSliceIterator()
+	if _, ok := nodes.NextOK(); ok {
+		t.Errorf("Expected end of nodes")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	g := NewWithPolicy(Policy{
+		Acyclic:         true,
+		NoSelfLoops:     true,
+		NoParallelEdges: true,
+		Schema:          NewSchema().Allow("a", AnyLabel, "a").Allow("a", "x", "b"),
+	})
+	a1 := NewBasicNode("a", nil)
+	a2 := NewBasicNode("a", nil)
+	b := NewBasicNode("b", nil)
+	g.AddNode(a1)
+	if err := g.Connect(a1, a1, NewBasicEdge("e", nil)); err != ErrSelfLoopForbidden {
+		t.Errorf("Expected ErrSelfLoopForbidden, got %v", err)
+	}
+	if err := g.Connect(a1, a2, NewBasicEdge("e", nil)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := g.Connect(a1, a2, NewBasicEdge("e", nil)); err != ErrParallelEdge {
+		t.Errorf("Expected ErrParallelEdge, got %v", err)
+	}
+	if err := g.Connect(a2, a1, NewBasicEdge("f", nil)); err != ErrCycle {
+		t.Errorf("Expected ErrCycle, got %v", err)
+	}
+	if err := g.Connect(a2, b, NewBasicEdge("y", nil)); err != ErrSchemaViolation {
+		t.Errorf("Expected ErrSchemaViolation, got %v", err)
+	}
+	if err := g.Connect(a2, b, NewBasicEdge("x", nil)); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestIncrementalCycleDetection(t *testing.T) {
+	g := NewWithPolicy(Policy{Acyclic: true})
+	rnd := rand.New(rand.NewSource(1))
+	nodes := make([]*BasicNode, 0)
+	for i := 0; i < 40; i++ {
+		nodes = append(nodes, NewBasicNode(i, nil))
+	}
+	g.AddNode(nodes[0])
+	// A subgraph built outside the graph joins it through an edge
+	Connect(nodes[30], nodes[31], NewBasicEdge(nil, nil))
+	Connect(nodes[31], nodes[32], NewBasicEdge(nil, nil))
+	for i := 0; i < 400; i++ {
+		from, to := nodes[rnd.Intn(len(nodes))], nodes[rnd.Intn(len(nodes))]
+		expected := from == to || Reachable(to, from)
+		err := g.Connect(from, to, NewBasicEdge(nil, nil))
+		if expected && err != ErrCycle {
+			t.Fatalf("Expected ErrCycle for %v -> %v, got %v", from.GetLabel(), to.GetLabel(), err)
+		}
+		if !expected && err != nil {
+			t.Fatalf("Unexpected error for %v -> %v: %v", from.GetLabel(), to.GetLabel(), err)
+		}
+	}
+	if g.topo == nil {
+		t.Fatalf("Topological order is not maintained")
+	}
+	for node, p := range g.topo.pos {
+		for _, next := range node.Next() {
+			if g.topo.pos[next] <= p {
+				t.Errorf("Edge %v -> %v is against the order", node.GetLabel(), next.GetLabel())
+			}
+		}
+	}
+
+	// Edges added to the nodes of another graph are not reported to
+	// g, so they must not bypass the check
+	g = New()
+	g.SetPolicy(Policy{Acyclic: true})
+	a, b := NewBasicNode("a", nil), NewBasicNode("b", nil)
+	g.AddNode(a)
+	other := New()
+	x := NewBasicNode("x", nil)
+	other.AddNode(x)
+	if err := g.Connect(a, x, NewBasicEdge(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	Connect(x, b, NewBasicEdge(nil, nil))
+	if err := g.Connect(b, a, NewBasicEdge(nil, nil)); err != ErrCycle {
+		t.Errorf("Expected ErrCycle through a foreign node, got %v", err)
+	}
+}
+
+func TestSyncGraph(t *testing.T) {
+	s := NewSyncGraph(nil)
+	root := NewBasicNode("root", nil)
+	s.AddNode(root)
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				for _, node := range s.NodesSlice() {
+					s.InSlice(node)
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if err := s.Connect(root, NewBasicNode(i, nil), NewBasicEdge("e", nil)); err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if n := len(s.NodesSlice()); n != 101 {
+		t.Errorf("Expected 101 nodes, got %d", n)
+	}
+	if err := s.Disconnect(nil); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	if err := s.Relabel(nil, "x"); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	if err := New().Disconnect(nil); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	if err := New().Relabel(nil, "x"); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	j := NewJournal(New())
+	if err := j.Disconnect(nil); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	if err := j.Relabel(nil, "x"); err != ErrNilEdge {
+		t.Errorf("Expected ErrNilEdge, got %v", err)
+	}
+	if err := s.Disconnect(s.OutSlice(root)[0]); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestParallelIndex(t *testing.T) {
+	g := New()
+	nodes := make([]*BasicNode, 0)
+	for i := 0; i < 50; i++ {
+		nodes = append(nodes, NewBasicNode(i%3, nil))
+	}
+	g.AddNode(nodes[0])
+	for i := 1; i < len(nodes); i++ {
+		Connect(nodes[i/2], nodes[i], NewBasicEdge(i%2, nil))
+		Connect(nodes[i], nodes[i/3], NewBasicEdge("back", nil))
+	}
+	seq := g.GetIndex()
+	par := g.GetIndex()
+	par.BuildParallel(4)
+	if len(seq.NodesSlice()) != len(par.NodesSlice()) {
+		t.Fatalf("Different number of nodes: %d %d", len(seq.NodesSlice()), len(par.NodesSlice()))
+	}
+	for _, node := range seq.NodesSlice() {
+		if len(seq.InSlice(node)) != len(par.InSlice(node)) ||
+			len(seq.InWithSlice(node, "back")) != len(par.InWithSlice(node, "back")) {
+			t.Errorf("Different incoming edges for %v", node.GetLabel())
+		}
+	}
+	if len(seq.NodesByLabelSlice(1)) != len(par.NodesByLabelSlice(1)) {
+		t.Errorf("Different nodes by label")
+	}
+
+	levels := make([]int32, len(nodes))
+	ParallelBFS([]Node{nodes[0]}, 4, func(node Node, level int) bool {
+		for i := range nodes {
+			if nodes[i] == node {
+				levels[i] = int32(level)
+			}
+		}
+		return true
+	})
+	if levels[1] != 1 || levels[4] != 3 {
+		t.Errorf("Wrong levels: %v", levels)
+	}
+
+	total := 0.0
+	for _, r := range PageRank(seq, 0.85, 20, 4) {
+		total += r
+	}
+	if total < 0.999 || total > 1.001 {
+		t.Errorf("Ranks do not add up to 1: %f", total)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	g := New()
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	n3 := NewBasicNode("3", nil)
+	g.AddNode(n1)
+	e12 := NewBasicEdge("a", nil)
+	Connect(n1, n2, e12)
+	Connect(n2, n3, NewBasicEdge("b", nil))
+	snap := g.Snapshot()
+
+	e12.Disconnect()
+	Connect(n1, n3, NewBasicEdge("c", nil))
+	n3.SetLabel("x")
+
+	if len(snap.NodesSlice()) != 3 {
+		t.Errorf("Expected 3 nodes in snapshot, got %d", len(snap.NodesSlice()))
+	}
+	out := snap.OutSlice(n1)
+	if len(out) != 1 || out[0] != e12 || snap.Target(out[0]) != n2 {
+		t.Errorf("Wrong snapshot edges: %v", out)
+	}
+	if snap.Label(n3) != "3" {
+		t.Errorf("Wrong snapshot label: %v", snap.Label(n3))
+	}
+	if len(snap.InSlice(n3)) != 1 {
+		t.Errorf("Wrong incoming edges")
+	}
+	if len(n1.Out().All()) != 1 || n1.Next()[0] != n3 {
+		t.Errorf("Graph not modified")
+	}
+	if e12.GetFrom() != nil || e12.getEdgeHeader().to != nil {
+		t.Errorf("Disconnected edge keeps its endpoints")
+	}
+
+	snap2 := g.Snapshot()
+	n4 := NewBasicNode("4", nil)
+	g.AddNode(n4)
+	Connect(n3, n4, NewBasicEdge("d", nil))
+	n1.SetLabel("y")
+	if len(snap2.NodesSlice()) != 2 || snap2.Contains(n2) || snap2.Label(n3) != "x" || len(snap2.OutSlice(n3)) != 0 {
+		t.Errorf("Wrong second snapshot: %v", snap2.NodesSlice())
+	}
+	if len(snap.NodesSlice()) != 3 || snap.Label(n1) != "1" || len(snap.OutSlice(n3)) != 0 {
+		t.Errorf("First snapshot changed")
+	}
+	snap3 := g.Snapshot()
+	if len(snap3.NodesSlice()) != 3 {
+		t.Errorf("Wrong third snapshot")
+	}
+
+	// States are saved while a snapshot is live
+	snap.Release()
+	snap2.Release()
+	n4.SetLabel("z")
+	if snap3.Label(n4) != "4" {
+		t.Errorf("Third snapshot changed")
+	}
+	snap3.Release()
+	snap3.Release()
+	n4.SetLabel("w")
+	if g.snaps != nil {
+		t.Errorf("States are saved after all snapshots are released")
+	}
+	if snap4 := g.Snapshot(); snap4.Label(n4) != "w" || len(snap4.NodesSlice()) != 3 {
+		t.Errorf("Wrong snapshot after release")
+	}
+}
+
+func TestConcurrentSnapshot(t *testing.T) {
+	g := New()
+	root := NewBasicNode(0, nil)
+	g.AddNode(root)
+	for i := 1; i < 10; i++ {
+		Connect(root, NewBasicNode(i, nil), NewBasicEdge("a", nil))
+	}
+	snap := g.Snapshot()
+	done := make(chan struct{})
+	// A snapshot released while the graph is modified
+	released := g.Snapshot()
+	go func() {
+		released.NodesSlice()
+		released.Release()
+		done <- struct{}{}
+	}()
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				for _, node := range snap.NodesSlice() {
+					snap.Label(node)
+					snap.OutWith(node, "a").All()
+				}
+			}
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		edge := root.Out().All()[0]
+		Relabel(edge, "b")
+		edge.Disconnect()
+		Connect(root, NewBasicNode(i, nil), NewBasicEdge("a", nil))
+		root.SetLabel(i)
+	}
+	for i := 0; i < 5; i++ {
+		<-done
+	}
+	if len(snap.NodesSlice()) != 10 || len(snap.OutWith(root, "a").All()) != 9 || snap.Label(root) != 0 {
+		t.Errorf("Snapshot changed")
+	}
+}
+
+func TestJournal(t *testing.T) {
+	g := New()
+	j := NewJournal(g)
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	j.AddNode(n1)
+	j.Begin()
+	j.Connect(n1, n2, NewBasicEdge("a", nil))
+	j.SetLabel(n2, "x")
+	j.Rollback()
+	if n1.HasOut() || n2.GetLabel() != "2" {
+		t.Errorf("Rollback failed")
+	}
+	j.Begin()
+	j.Connect(n1, n2, NewBasicEdge("a", nil))
+	j.SetLabel(n2, "x")
+	j.Commit()
+	j.RemoveNode(n2)
+	if n1.HasOut() {
+		t.Errorf("Node not removed")
+	}
+	if err := j.Undo(); err != nil || len(n1.Next()) != 1 {
+		t.Errorf("Undo remove failed: %v", err)
+	}
+	j.Undo()
+	if n1.HasOut() || n2.GetLabel() != "2" {
+		t.Errorf("Undo transaction failed")
+	}
+	j.Redo()
+	if len(n1.Next()) != 1 || n2.GetLabel() != "x" {
+		t.Errorf("Redo failed")
+	}
+	j.Undo()
+	j.Undo()
+	if err := j.Undo(); err != ErrNothingToUndo {
+		t.Errorf("Expected ErrNothingToUndo, got %v", err)
+	}
+	if len(g.roots()) != 0 {
+		t.Errorf("Undo add node failed")
+	}
+
+	// Undo reconnects the edges of a removed node at their original
+	// positions and reports them
+	g = New()
+	j = NewJournal(g)
+	a, b, c, n := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil), NewBasicNode("n", nil)
+	g.AddNode(a)
+	Connect(a, b, NewBasicEdge("1", nil))
+	Connect(a, n, NewBasicEdge("2", nil))
+	Connect(a, c, NewBasicEdge("3", nil))
+	Connect(n, c, NewBasicEdge("4", nil))
+	Connect(n, b, NewBasicEdge("5", nil))
+	labels := func(node Node) string {
+		ret := ""
+		for _, edge := range node.Out().All() {
+			ret += edge.GetLabel().(string)
+		}
+		return ret
+	}
+	connected := 0
+	g.AddListener(func(e Event) {
+		if e.Type == EdgeConnected {
+			connected++
+		}
+	})
+	j.RemoveNode(n)
+	if err := j.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if connected != 3 {
+		t.Errorf("Expected 3 EdgeConnected events, got %d", connected)
+	}
+	if labels(a) != "123" || labels(n) != "45" {
+		t.Errorf("Wrong edge order after undo: %s %s", labels(a), labels(n))
+	}
+	edge := GetEdge(a, b, "1")
+	j.Disconnect(edge)
+	j.Undo()
+	if labels(a) != "123" {
+		t.Errorf("Wrong edge order after undoing disconnect: %s", labels(a))
+	}
+}
+
+func TestListeners(t *testing.T) {
+	g := New()
+	events := make([]EventType, 0)
+	id := g.AddListener(func(e Event) { events = append(events, e.Type) })
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	g.AddNode(n1)
+	edge := NewBasicEdge("a", nil)
+	Connect(n1, n2, edge)
+	n2.SetLabel("x")
+	edge.Disconnect()
+	g.RemoveNode(n1)
+	g.RemoveListener(id)
+	g.AddNode(n2)
+	expected := []EventType{NodeAdded, EdgeConnected, NodeLabelChanged, EdgeDisconnected, NodeRemoved}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, events)
+		}
+	}
+
+	// Nodes accessible from a node joining the graph also join it
+	g = New()
+	events = events[:0]
+	g.AddListener(func(e Event) { events = append(events, e.Type) })
+	a, b, c, d := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil), NewBasicNode("d", nil)
+	Connect(b, c, NewBasicEdge("e", nil))
+	g.AddNode(a)
+	if err := g.Connect(a, b, NewBasicEdge("e", nil)); err != nil {
+		t.Fatal(err)
+	}
+	events = events[:0]
+	c.SetLabel("x")
+	Connect(c, d, NewBasicEdge("e", nil))
+	if len(events) != 2 || events[0] != NodeLabelChanged || events[1] != EdgeConnected {
+		t.Errorf("Expected events for accessible nodes, got %v", events)
+	}
+	g.RemoveNode(c)
+	events = events[:0]
+	c.SetLabel("y")
+	if len(events) != 0 || c.getNodeHeader().graph != nil {
+		t.Errorf("Removed node still belongs to the graph")
+	}
+}
+
+func TestRelabel(t *testing.T) {
+	g := New()
+	root := NewBasicNode("root", nil)
+	target := NewBasicNode("target", nil)
+	g.AddNode(root)
+	edges := make([]*BasicEdge, 0)
+	for i := 0; i < 20; i++ {
+		edge := NewBasicEdge(i%2, nil)
+		edges = append(edges, edge)
+		Connect(root, target, edge)
+	}
+	index := g.GetIndex()
+	index.InWithSlice(target, 0)
+	index.Relabel(edges[5], 0)
+	all := root.Out().All()
+	if all[5] != edges[5] {
+		t.Errorf("Edge position changed")
+	}
+	with := root.OutWith(0).All()
+	if len(with) != 11 || with[3] != edges[5] {
+		t.Errorf("Wrong edges with label: %d", len(with))
+	}
+	in := index.InWithSlice(target, 0)
+	if len(in) != 11 || in[3] != edges[5] {
+		t.Errorf("Wrong index edges with label: %d", len(in))
+	}
+	if len(index.InWithSlice(target, 1)) != 9 {
+		t.Errorf("Wrong index edges with old label")
+	}
+
+	// Snapshots keep the old label
+	e := NewBasicEdge("old", nil)
+	Connect(root, target, e)
+	snap := g.Snapshot()
+	g.Relabel(e, "new")
+	if len(snap.OutWith(root, "old").All()) != 1 || len(snap.OutWith(root, "new").All()) != 0 || snap.EdgeLabel(e) != "old" {
+		t.Errorf("Snapshot sees the new label")
+	}
+	if len(root.OutWith("new").All()) != 1 {
+		t.Errorf("Edge not relabeled")
+	}
+}
+
+type pathLabel struct {
+	path []string
+}
+
+func (p pathLabel) LabelKey() interface{} { return strings.Join(p.path, "/") }
+
+func TestNonComparableLabels(t *testing.T) {
+	g := New()
+	root := NewBasicNode([]string{"a", "b"}, nil)
+	g.AddNode(root)
+	for i := 0; i < 20; i++ {
+		Connect(root, NewBasicNode(map[string]int{"x": i % 2}, nil), NewBasicEdge([]string{"e", fmt.Sprint(i % 2)}, nil))
+		Connect(root, NewBasicNode(nil, nil), NewBasicEdge(pathLabel{[]string{"p", fmt.Sprint(i % 2)}}, nil))
+	}
+	if n := len(root.OutWith([]string{"e", "1"}).All()); n != 10 {
+		t.Errorf("Expected 10 edges, got %d", n)
+	}
+	if n := len(root.NextWith(pathLabel{[]string{"p", "0"}})); n != 10 {
+		t.Errorf("Expected 10 nodes, got %d", n)
+	}
+	index := g.GetIndex()
+	if n := len(index.NodesByLabelSlice(map[string]int{"x": 0})); n != 10 {
+		t.Errorf("Expected 10 nodes, got %d", n)
+	}
+	if n := len(index.NodesByLabelSlice([]string{"a", "b"})); n != 1 {
+		t.Errorf("Expected 1 node, got %d", n)
+	}
+	type withInterface struct{ v interface{} }
+	if _, ok := LabelKey(withInterface{[]int{1}}).(derivedKey); !ok {
+		t.Errorf("Expected derived key for non-comparable interface field")
+	}
+	if LabelKey(withInterface{1}) != (withInterface{1}) {
+		t.Errorf("Expected label as key for comparable interface field")
+	}
+
+	// Edges are removed by the key computed when they are connected
+	for _, storage := range allStorages {
+		n := NewBasicNode("n", nil)
+		n.SetEdgeStorage(storage)
+		label := []string{"a"}
+		edges := make([]Edge, 0)
+		for i := 0; i < 12; i++ {
+			edge := NewBasicEdge(label, nil)
+			Connect(n, NewBasicNode(i, nil), edge)
+			edges = append(edges, edge)
+		}
+		label[0] = "b"
+		edges[3].Disconnect()
+		if k := len(n.Out().All()); k != 11 {
+			t.Errorf("%v: expected 11 edges, got %d", storage, k)
+		}
+		if k := len(n.OutWith([]string{"a"}).All()); k != 11 {
+			t.Errorf("%v: expected 11 edges with label, got %d", storage, k)
+		}
+	}
+}
+
+func TestEdgesBetween(t *testing.T) {
+	root := NewBasicNode("root", nil)
+	targets := make([]*BasicNode, 0)
+	for i := 0; i < 20; i++ {
+		target := NewBasicNode(i, nil)
+		targets = append(targets, target)
+		Connect(root, target, NewBasicEdge("a", nil))
+	}
+	edge := NewBasicEdge("b", nil)
+	Connect(root, targets[3], edge)
+	if n := len(EdgesBetween(root, targets[3]).All()); n != 2 {
+		t.Errorf("Expected 2 edges, got %d", n)
+	}
+	if GetEdge(root, targets[3], "b") != edge {
+		t.Errorf("Wrong edge")
+	}
+	if !HasEdge(root, targets[4], AnyLabel) || HasEdge(root, targets[4], "b") || HasEdge(targets[4], root, AnyLabel) {
+		t.Errorf("Wrong HasEdge")
+	}
+}
+
+func TestFrozenGraph(t *testing.T) {
+	g := New()
+	nodes := make([]*BasicNode, 0)
+	for i := 0; i < 6; i++ {
+		nodes = append(nodes, NewBasicNode(i%2, nil))
+	}
+	g.AddNode(nodes[0])
+	Connect(nodes[0], nodes[1], NewBasicEdge("a", nil))
+	Connect(nodes[0], nodes[2], NewBasicEdge("b", nil))
+	Connect(nodes[1], nodes[3], NewBasicEdge("a", nil))
+	Connect(nodes[2], nodes[3], NewBasicEdge("a", nil))
+	Connect(nodes[3], nodes[4], NewBasicEdge("a", nil))
+	Connect(nodes[4], nodes[1], NewBasicEdge("c", nil))
+	Connect(nodes[4], nodes[5], NewBasicEdge("c", nil))
+	index := g.GetIndex()
+	f := Freeze(index)
+	if f.NumNodes() != 6 || f.NumEdges() != 7 {
+		t.Fatalf("Wrong size: %d %d", f.NumNodes(), f.NumEdges())
+	}
+	id := func(n Node) int { x, _ := f.NodeID(n); return x }
+	if len(f.In(id(nodes[3]))) != 2 || len(f.In(id(nodes[1]))) != 2 {
+		t.Errorf("Wrong incoming edges")
+	}
+	if s := f.Sinks(); len(s) != 1 || f.Node(s[0]) != nodes[5] {
+		t.Errorf("Wrong sinks")
+	}
+	if s := f.Sources(); len(s) != 1 || f.Node(s[0]) != nodes[0] {
+		t.Errorf("Wrong sources")
+	}
+	if !f.Reachable(id(nodes[2]), id(nodes[1])) || f.Reachable(id(nodes[5]), id(nodes[0])) {
+		t.Errorf("Wrong reachability")
+	}
+	if len(f.NodesByLabel(1)) != 3 {
+		t.Errorf("Wrong nodes by label")
+	}
+	iterated := make([]Node, 0)
+	f.IterateAll(func(n int) bool { iterated = append(iterated, f.Node(n)); return true }, func(int) bool { return true })
+	expected := make([]Node, 0)
+	IterateGraph(g, func(n Node) bool { expected = append(expected, n); return true }, func(Edge) bool { return true })
+	for i := range expected {
+		if iterated[i] != expected[i] {
+			t.Errorf("Different iteration order")
+		}
+	}
+	levels := make([]int32, f.NumNodes())
+	f.ParallelBFS([]int{id(nodes[0])}, 3, func(n, level int) bool { levels[n] = int32(level); return true })
+	if levels[id(nodes[4])] != 3 {
+		t.Errorf("Wrong level: %v", levels)
+	}
+
+	g2 := New()
+	nodeMap := CopyGraph(g2, g, func(n Node) Node { return NewBasicNode(n.GetLabel(), nil) }, func(e Edge) Edge { return NewBasicEdge(e.GetLabel(), nil) })
+	f2 := Freeze(g2.GetIndex())
+	if !f.CheckIsomorphism(f2, func(n1, n2 Node) bool { return nodeMap[n1] == n2 }, func(e1, e2 Edge) bool { return true }) {
+		t.Errorf("Not isomorphic")
+	}
+}
+
+func TestNodeIDs(t *testing.T) {
+	g := New()
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	x := NewBasicNode("x", nil)
+	n3 := NewBasicNode("3", nil)
+	g.AddNode(n1)
+	Connect(n1, n2, NewBasicEdge("a", nil))
+	// n3 is connected before x joins the graph, and joins with x
+	Connect(x, n3, NewBasicEdge("a", nil))
+	Connect(n2, x, NewBasicEdge("a", nil))
+	if n1.ID() == 0 || n2.ID() <= n1.ID() || x.ID() <= n2.ID() || n3.ID() <= x.ID() {
+		t.Errorf("Wrong IDs: %d %d %d %d", n1.ID(), n2.ID(), x.ID(), n3.ID())
+	}
+	if g.NodeByID(n2.ID()) != n2 {
+		t.Errorf("Wrong node by ID")
+	}
+	if g.NodeByID(n3.ID()) != n3 {
+		t.Errorf("Node not registered")
+	}
+
+	// Nodes of different graphs have different IDs, so graphs can be
+	// merged
+	g1, g2 := New(), New()
+	a, b := NewBasicNode("a", nil), NewBasicNode("b", nil)
+	g1.AddNode(a)
+	g2.AddNode(b)
+	if a.ID() == b.ID() {
+		t.Errorf("Nodes of different graphs have the same ID")
+	}
+	if err := g2.TryAddNode(a); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if g2.NodeByID(a.ID()) != a || g1.NodeByID(a.ID()) != a {
+		t.Errorf("Wrong node by ID after merge")
+	}
+
+	// Rendering does not assign IDs
+	g3 := New()
+	c := NewBasicNode("c", nil)
+	g3.nodes[c] = struct{}{}
+	g3.order = append(g3.order, c)
+	(DOTRenderer{}).Render(g3, "g", &strings.Builder{})
+	if c.ID() != 0 || len(g3.byID) != 0 {
+		t.Errorf("Rendering modified the graph")
+	}
+}
+
+func TestDeterministicDOT(t *testing.T) {
+	build := func() *Graph {
+		g := New()
+		nodes := make([]Node, 0)
+		for i := 0; i < 5; i++ {
+			node := NewBasicNode(fmt.Sprint("node", i), nil)
+			nodes = append(nodes, node)
+			g.AddNode(node)
+		}
+		for i := 1; i < 5; i++ {
+			Connect(nodes[i], nodes[i-1], NewBasicEdge("e", nil))
+		}
+		return g
+	}
+	expected := `digraph g {
+rankdir="LR";
+  n1 [label="node0"];
+  n2 [label="node1"];
+  n3 [label="node2"];
+  n4 [label="node3"];
+  n5 [label="node4"];
+  n2 -> n1 [label="e"];
+  n3 -> n2 [label="e"];
+  n4 -> n3 [label="e"];
+  n5 -> n4 [label="e"];
+}
+`
+	// Each graph is built with new node IDs, but is rendered the same
+	for i := 0; i < 5; i++ {
+		out := strings.Builder{}
+		if err := (DOTRenderer{}).Render(build(), "g", &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("Unexpected output: %s", out.String())
+		}
+	}
+	out := strings.Builder{}
+	DOTRenderer{NodeLess: func(a, b Node) bool { return a.ID() > b.ID() }}.Render(build(), "g", &out)
+	if !strings.HasPrefix(out.String(), "digraph g {\nrankdir=\"LR\";\n  n5 ") {
+		t.Errorf("Unexpected sorted output: %s", out.String())
+	}
+}
+
+func TestClosedGraph(t *testing.T) {
+	g := NewClosed()
+	n1 := NewBasicNode("1", nil)
+	n2 := NewBasicNode("2", nil)
+	n3 := NewBasicNode("3", nil)
+	g.AddNode(n1)
+	Connect(n1, n2, NewBasicEdge("a", nil))
+	g.Connect(n2, n3, NewBasicEdge("a", nil))
+	g.AddNode(NewBasicNode("isolated", nil))
+	if g.NodeCount() != 4 || g.EdgeCount() != 2 || !g.Contains(n3) {
+		t.Errorf("Wrong counts: %d %d", g.NodeCount(), g.EdgeCount())
+	}
+	if n := len(g.GetIndex().NodesSlice()); n != 4 {
+		t.Errorf("Expected 4 nodes in index, got %d", n)
+	}
+	other := New()
+	o := NewBasicNode("o", nil)
+	other.AddNode(o)
+	if err := TryConnect(o, n1, NewBasicEdge("a", nil)); err != ErrForeignNode {
+		t.Errorf("Expected ErrForeignNode, got %v", err)
+	}
+	if err := other.TryAddNode(n1); err != ErrForeignNode {
+		t.Errorf("Expected ErrForeignNode, got %v", err)
+	}
+	if err := TryConnect(NewBasicNode("free", nil), n1, NewBasicEdge("a", nil)); err != ErrForeignNode {
+		t.Errorf("Expected ErrForeignNode, got %v", err)
+	}
+	g.RemoveNode(n2)
+	if g.NodeCount() != 3 || g.EdgeCount() != 0 || g.Contains(n2) {
+		t.Errorf("Wrong counts after remove: %d %d", g.NodeCount(), g.EdgeCount())
+	}
+
+	// Nodes accessible from a joining node join with their edges
+	g = NewClosed()
+	a, b, c := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil)
+	Connect(b, c, NewBasicEdge("e", nil))
+	g.AddNode(a)
+	Connect(a, b, NewBasicEdge("e", nil))
+	if g.NodeCount() != 3 || g.EdgeCount() != 2 || !g.Contains(c) {
+		t.Errorf("Wrong counts after join: %d %d", g.NodeCount(), g.EdgeCount())
+	}
+	x, y := NewBasicNode("x", nil), NewBasicNode("y", nil)
+	xy := NewBasicEdge("e", nil)
+	Connect(x, y, xy)
+	g.AddNode(x)
+	if g.NodeCount() != 5 || g.EdgeCount() != 3 {
+		t.Errorf("Wrong counts after add: %d %d", g.NodeCount(), g.EdgeCount())
+	}
+	g.Disconnect(xy)
+	if g.EdgeCount() != 2 {
+		t.Errorf("Wrong edge count after disconnect: %d", g.EdgeCount())
+	}
+	// A node that reaches a node of another graph cannot join
+	z := NewBasicNode("z", nil)
+	Connect(z, o, NewBasicEdge("e", nil))
+	if err := g.TryAddNode(z); err != ErrForeignNode {
+		t.Errorf("Expected ErrForeignNode, got %v", err)
+	}
+}
+
+func TestDOTAttributes(t *testing.T) {
+	g := New()
+	n1 := NewBasicNode("say \"hi\"\nthere", nil)
+	n2 := NewBasicNode("b", nil)
+	g.AddNode(n1)
+	Connect(n1, n2, NewBasicEdge(1, nil))
+	out := strings.Builder{}
+	err := DOTRenderer{
+		GraphAttributes: DOTAttributes{"rankdir": "TB", "label": "my graph"},
+		NodeDefaults:    DOTAttributes{"shape": "box"},
+		NodeAttributes: func(n Node) (DOTAttributes, bool) {
+			if n == n2 {
+				return DOTAttributes{"label": DOTHTML("<b>b</b>")}, true
+			}
+			return DefaultDOTNodeAttributes(n), true
+		},
+	}.Render(g, "my graph", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph "my graph" {
+label="my graph";
+rankdir="TB";
+node [shape="box"];
+  n1 [label="say \"hi\"\nthere"];
+  n2 [label=<<b>b</b>>];
+  n1 -> n2 [label="1"];
+}
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+}
+
+func TestDOTClusters(t *testing.T) {
+	g := New()
+	nodes := []*BasicNode{NewBasicNode("api", nil), NewBasicNode("db", nil), NewBasicNode("web", nil), NewBasicNode("lb", nil)}
+	clusters := map[Node][]string{
+		nodes[0]: {"backend", "prod"},
+		nodes[1]: {"backend", "prod"},
+		nodes[2]: {"frontend"},
+	}
+	for _, n := range nodes {
+		g.AddNode(n)
+	}
+	Connect(nodes[0], nodes[1], NewBasicEdge(nil, nil))
+	Connect(nodes[2], nodes[0], NewBasicEdge(nil, nil))
+	Connect(nodes[3], nodes[2], NewBasicEdge(nil, nil))
+	out := strings.Builder{}
+	err := DOTRenderer{
+		Cluster: func(n Node) []string { return clusters[n] },
+		ClusterAttributes: func(path []string) DOTAttributes {
+			return DOTAttributes{"label": strings.Join(path, "/")}
+		},
+	}.Render(g, "g", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `digraph g {
+rankdir="LR";
+  n4 [label="lb"];
+  subgraph cluster_0 {
+    graph [label="backend"];
+    subgraph cluster_1 {
+      graph [label="backend/prod"];
+      n1 [label="api"];
+      n2 [label="db"];
+    }
+  }
+  subgraph cluster_2 {
+    graph [label="frontend"];
+    n3 [label="web"];
+  }
+  n1 -> n2;
+  n3 -> n1;
+  n4 -> n3;
+}
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected output: %s", out.String())
+	}
+}
+
+func TestMermaidPlantUML(t *testing.T) {
+	g := New()
+	n1 := NewBasicNode("say \"hi\"", nil)
+	n2 := NewBasicNode(nil, nil)
+	g.AddNode(n1)
+	Connect(n1, n2, NewBasicEdge("a\nb", nil))
+	out := strings.Builder{}
+	if err := (MermaidRenderer{}).Render(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "flowchart LR\n  n1[\"say #quot;hi#quot;\"]\n  n2\n  n1 -->|\"a<br/>b\"| n2\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected mermaid output: %s", out.String())
+	}
+	out.Reset()
+	if err := (PlantUMLRenderer{}).Render(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected = "@startuml\nrectangle \"say <U+0022>hi<U+0022>\" as n1\nrectangle \"n2\" as n2\nn1 --> n2 : \"a\\nb\"\n@enduml\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected plantuml output: %s", out.String())
+	}
+	for _, s := range []string{"a-b", "a_b", "end", "id_x", "1"} {
+		if id := MermaidID(s); !safeIDRegex.MatchString(id) {
+			t.Errorf("Invalid ID for %s: %s", s, id)
+		}
+	}
+	if MermaidID("a-b") == MermaidID("a_b") {
+		t.Errorf("IDs collide")
+	}
+}
+
+func TestLayout(t *testing.T) {
+	g := New()
+	nodes := make([]*BasicNode, 0)
+	for i := 0; i < 5; i++ {
+		nodes = append(nodes, NewBasicNode(i, nil))
+	}
+	g.AddNode(nodes[0])
+	Connect(nodes[0], nodes[1], NewBasicEdge(nil, nil))
+	Connect(nodes[1], nodes[2], NewBasicEdge(nil, nil))
+	Connect(nodes[0], nodes[2], NewBasicEdge("long", nil))
+	Connect(nodes[2], nodes[0], NewBasicEdge("back", nil))
+	Connect(nodes[0], nodes[3], NewBasicEdge(nil, nil))
+	Connect(nodes[3], nodes[3], NewBasicEdge(nil, nil))
+	Connect(nodes[3], nodes[4], NewBasicEdge(nil, nil))
+
+	layout := LayoutGraph(g, LayoutOptions{})
+	if layout.Layers != 3 {
+		t.Errorf("Expected 3 layers, got %d", layout.Layers)
+	}
+	layers := map[int]int{}
+	for _, n := range layout.Nodes {
+		layers[n.Node.GetLabel().(int)] = n.Layer
+	}
+	if layers[0] != 0 || layers[1] != 1 || layers[2] != 2 || layers[3] != 1 || layers[4] != 2 {
+		t.Errorf("Wrong layers: %v", layers)
+	}
+	for _, a := range layout.Nodes {
+		for _, b := range layout.Nodes {
+			if a.Node != b.Node && a.Layer == b.Layer && a.X+a.Width/2 > b.X-b.Width/2 && a.X < b.X {
+				t.Errorf("Nodes overlap: %v %v", a.Node.GetLabel(), b.Node.GetLabel())
+			}
+		}
+	}
+	for _, e := range layout.Edges {
+		from, _ := layout.NodeLayout(e.Edge.GetFrom())
+		to, _ := layout.NodeLayout(e.Edge.GetTo())
+		first, last := e.Points[0], e.Points[len(e.Points)-1]
+		if first.X != from.X && from != to || last.X != to.X && from != to {
+			t.Errorf("Edge does not start and end at its nodes")
+		}
+		switch e.Edge.GetLabel() {
+		case "long":
+			if len(e.Points) != 3 {
+				t.Errorf("Expected a dummy node on long edge, got %v", e.Points)
+			}
+		case "back":
+			if !e.Reversed || first.Y < last.Y {
+				t.Errorf("Expected back edge to be reversed")
+			}
+		}
+	}
+
+	out := strings.Builder{}
+	if err := (SVGRenderer{}).Render(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	svg := out.String()
+	if !strings.HasPrefix(svg, "<svg ") || strings.Count(svg, "<rect ") != 5 || strings.Count(svg, "<polyline ") != 7 {
+		t.Errorf("Unexpected svg: %s", svg)
+	}
+}
+
+func TestCrossingReduction(t *testing.T) {
+	// Two layers with edges a->y, b->x that cross in insertion order
+	g := New()
+	a, b, x, y := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("x", nil), NewBasicNode("y", nil)
+	g.AddNode(a)
+	g.AddNode(b)
+	g.AddNode(x)
+	g.AddNode(y)
+	Connect(a, y, NewBasicEdge(nil, nil))
+	Connect(b, x, NewBasicEdge(nil, nil))
+	Connect(a, x, NewBasicEdge(nil, nil))
+	layout := LayoutGraph(g, LayoutOptions{})
+	la, _ := layout.NodeLayout(a)
+	lb, _ := layout.NodeLayout(b)
+	lx, _ := layout.NodeLayout(x)
+	ly, _ := layout.NodeLayout(y)
+	if (la.X < lb.X) != (ly.X < lx.X) {
+		t.Errorf("Edges cross: a=%v b=%v x=%v y=%v", la.X, lb.X, lx.X, ly.X)
+	}
+}
+
+func TestTextRenderer(t *testing.T) {
+	g := New()
+	a, b, c, d := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil), NewBasicNode("d", nil)
+	g.AddNode(a)
+	Connect(a, b, NewBasicEdge("x", nil))
+	Connect(a, c, NewBasicEdge(nil, nil))
+	Connect(b, d, NewBasicEdge(nil, nil))
+	Connect(c, d, NewBasicEdge(nil, nil))
+
+	out := strings.Builder{}
+	if err := (TextRenderer{}).RenderTree(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `a
+├── (x) b
+│   └── d
+└── c
+    └── d (*)
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected tree:\n%s", out.String())
+	}
+
+	out.Reset()
+	Connect(d, a, NewBasicEdge(nil, nil))
+	if err := (TextRenderer{ASCII: true}).RenderTree(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected = "a\n|-- (x) b\n|   `-- d\n|       `-- a (*)\n`-- c\n    `-- d (*)\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected tree:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := TextRenderer{
+		ASCII: true,
+		NodeLabel: func(n Node) (string, bool) {
+			return n.GetLabel().(string), n != d
+		},
+	}.RenderBoxes(g, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `    +---+
+    | a |
+    +---+
+      ||
+  +---++-+
+  vx     v
++---+  +---+
+| b |  | c |
++---+  +---+
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected boxes:\n%s", out.String())
+	}
+}
+
+func TestExporters(t *testing.T) {
+	g := New()
+	a, b := NewBasicNode("a \"q\"", 1), NewBasicNode("b", 2)
+	g.AddNode(a)
+	Connect(a, b, NewBasicEdge("x", nil))
+	payload := func(n Node) Attributes { return Attributes{"payload": n.(*BasicNode).Payload} }
+
+	out := strings.Builder{}
+	err := GEXFExporter{
+		NodeAttributes: func(n Node) Attributes {
+			return Attributes{"weight": []GEXFSpell{{Value: 1, End: "2"}, {Value: 2, Start: "2"}}}
+		},
+		EdgeInterval: func(Edge) (string, string) { return "1", "" },
+	}.Export(g, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<graph defaultedgetype="directed" mode="dynamic" timeformat="double">`,
+		`<attributes class="node" mode="dynamic">`,
+		`<attribute id="0" title="weight" type="long"></attribute>`,
+		`<node id="n1" label="a &#34;q&#34;">`,
+		`<attvalue for="0" value="2" start="2"></attvalue>`,
+		`<edge id="e0" source="n1" target="n2" label="x" start="1"></edge>`,
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("Missing %s in GEXF: %s", s, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := (GMLExporter{NodeAttributes: payload}).Export(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `graph [
+  directed 1
+  node [
+    id 1
+    label "a &quot;q&quot;"
+    payload 1
+  ]
+  node [
+    id 2
+    label "b"
+    payload 2
+  ]
+  edge [
+    source 1
+    target 2
+    label "x"
+  ]
+]
+`
+	if out.String() != expected {
+		t.Errorf("Unexpected GML: %s", out.String())
+	}
+
+	out.Reset()
+	if err := (CytoscapeExporter{NodeAttributes: payload}).Export(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Elements struct {
+			Nodes []struct{ Data map[string]interface{} }
+			Edges []struct{ Data map[string]interface{} }
+		}
+	}
+	if err := json.Unmarshal([]byte(out.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Elements.Nodes) != 2 || doc.Elements.Nodes[0].Data["label"] != "a \"q\"" || doc.Elements.Nodes[1].Data["payload"] != 2.0 {
+		t.Errorf("Unexpected nodes: %v", doc.Elements.Nodes)
+	}
+	if len(doc.Elements.Edges) != 1 || doc.Elements.Edges[0].Data["source"] != "n1" || doc.Elements.Edges[0].Data["target"] != "n2" {
+		t.Errorf("Unexpected edges: %v", doc.Elements.Edges)
+	}
+}
+
+func TestEdgeList(t *testing.T) {
+	input := "from,to,label,weight\na,b,x,1\nb,c,\"y,z\",2\nc,a,x,3\n"
+	g := New()
+	weights := map[Edge]string{}
+	nodes, err := EdgeListReader{
+		Header: true,
+		NewEdge: func(r CSVRecord) (Edge, error) {
+			label, _ := r.Get("label")
+			edge := NewBasicEdge(label, nil)
+			weights[edge], _ = r.Get("weight")
+			return edge, nil
+		},
+	}.Read(g, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 || len(weights) != 3 {
+		t.Errorf("Expected 3 nodes and 3 edges, got %d %d", len(nodes), len(weights))
+	}
+	if e := GetEdge(nodes["b"], nodes["c"], "y,z"); e == nil || weights[e] != "2" {
+		t.Errorf("Wrong edge b->c")
+	}
+
+	out := strings.Builder{}
+	err = EdgeListWriter{
+		Comma:   '\t',
+		Header:  []string{"from", "to", "label", "weight"},
+		Columns: func(e Edge) []string { return []string{weights[e]} },
+	}.Write(g, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "from\tto\tlabel\tweight\na\tb\tx\t1\nb\tc\ty,z\t2\nc\ta\tx\t3\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected TSV: %q", out.String())
+	}
+
+	g2 := New()
+	nodes, err = EdgeListReader{Comma: '\t', Header: true}.Read(g2, strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasEdge(nodes["c"], nodes["a"], "x") {
+		t.Errorf("Missing edge c->a")
+	}
+
+	// Empty TSV fields are not skipped
+	nodes, err = EdgeListReader{Comma: '\t'}.Read(New(), strings.NewReader("a\tb\t\textra\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasEdge(nodes["a"], nodes["b"], "") || HasEdge(nodes["a"], nodes["b"], "extra") {
+		t.Errorf("Wrong label with an empty TSV field")
+	}
+
+	_, err = EdgeListReader{}.Read(NewWithPolicy(Policy{Acyclic: true}), strings.NewReader("a,b\nb,a\n"))
+	if !errors.Is(err, ErrCycle) || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Expected cycle error on line 2, got %v", err)
+	}
+	_, err = EdgeListReader{}.Read(New(), strings.NewReader("a,b\n,c\n"))
+	if !errors.Is(err, ErrEmptyID) || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Expected ErrEmptyID on line 2, got %v", err)
+	}
+	_, err = EdgeListReader{Header: true, FromColumn: "src"}.Read(New(), strings.NewReader(input))
+	if !errors.Is(err, ErrMissingColumn) {
+		t.Errorf("Expected missing column, got %v", err)
+	}
+}
+
+func TestAdjacencyList(t *testing.T) {
+	g := New()
+	nodes, err := AdjacencyListReader{}.Read(g, strings.NewReader("# comment\na b c\nb c\n\nd\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 4 || !HasEdge(nodes["a"], nodes["c"], AnyLabel) || len(nodes["d"].Out().All()) != 0 {
+		t.Errorf("Wrong graph")
+	}
+	out := strings.Builder{}
+	if err := (AdjacencyListWriter{Delimiter: ","}).Write(g, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a,b,c\nb,c\nc\nd\n" {
+		t.Errorf("Unexpected adjacency list: %q", out.String())
+	}
+
+	g = New()
+	nodes, err = AdjacencyListReader{Delimiter: ","}.Read(g, strings.NewReader("a,b,\nb,,c\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nodes[""]; ok || len(nodes) != 3 || !HasEdge(nodes["b"], nodes["c"], AnyLabel) {
+		t.Errorf("Wrong graph with empty IDs: %v", nodes)
+	}
+	_, err = AdjacencyListReader{Delimiter: ","}.Read(New(), strings.NewReader("a,b\n,c\n"))
+	if !errors.Is(err, ErrEmptyID) || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("Expected ErrEmptyID on line 2, got %v", err)
+	}
+
+	// Lines are not limited in length
+	line := strings.Builder{}
+	line.WriteString("hub")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&line, " n%d", i)
+	}
+	nodes, err = AdjacencyListReader{}.Read(New(), strings.NewReader(line.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 20001 || len(nodes["hub"].Out().All()) != 20000 {
+		t.Errorf("Wrong graph for a long line: %d nodes", len(nodes))
+	}
+}
+
+func TestMatrices(t *testing.T) {
+	g := New()
+	a, b, c, d := NewBasicNode("a", nil), NewBasicNode("b", nil), NewBasicNode("c", nil), NewBasicNode("d", nil)
+	g.AddNode(a)
+	g.AddNode(d)
+	Connect(a, b, NewBasicEdge(nil, 2.0))
+	Connect(a, b, NewBasicEdge(nil, 1.0))
+	Connect(b, c, NewBasicEdge(nil, 1.0))
+	Connect(c, a, NewBasicEdge(nil, 1.0))
+	Connect(d, a, NewBasicEdge(nil, 1.0))
+	index := g.GetIndex()
+	opts := MatrixOptions{
+		Order:  []Node{a, b, c, d},
+		Weight: func(e Edge) float64 { return e.(*BasicEdge).Payload.(float64) },
+	}
+	dense, _ := index.DenseAdjacency(opts)
+	sparse, nodes := index.SparseAdjacency(opts)
+	if nodes[3] != d || sparse.NNZ() != 4 {
+		t.Errorf("Wrong sparse matrix: %v", sparse)
+	}
+	if dense.At(0, 1) != 3 || sparse.At(0, 1) != 3 || sparse.At(1, 0) != 0 || dense.At(3, 0) != 1 {
+		t.Errorf("Wrong weights")
+	}
+	for i, v := range sparse.Dense().Data {
+		if dense.Data[i] != v {
+			t.Errorf("Dense and sparse differ at %d", i)
+		}
+	}
+
+	lap := dense.InDegreeLaplacian()
+	// a has incoming edges from c and d
+	if lap.At(0, 0) != 2 || lap.At(2, 0) != -1 || lap.At(1, 1) != 3 || lap.At(3, 3) != 0 {
+		t.Errorf("Wrong Laplacian: %v", lap.Data)
+	}
+	for j := 0; j < 4; j++ {
+		sum := 0.0
+		for i := 0; i < 4; i++ {
+			sum += lap.At(i, j)
+		}
+		if sum != 0 {
+			t.Errorf("Laplacian column %d does not sum to 0", j)
+		}
+	}
+
+	adj := sparse.Bool()
+	closure := adj.Closure()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if !closure.Test(i, j) {
+				t.Errorf("%d should reach %d", i, j)
+			}
+		}
+		if closure.Test(i, 3) {
+			t.Errorf("%d should not reach d", i)
+		}
+	}
+	if !closure.Test(3, 2) || closure.Test(3, 3) {
+		t.Errorf("Wrong closure for d")
+	}
+	if p := adj.Power(3); !p.Test(0, 0) || p.Test(0, 1) || !p.Test(3, 2) {
+		t.Errorf("Wrong third power")
+	}
+	if p := adj.Power(0); !p.Test(0, 0) || p.Test(0, 1) || !p.Test(3, 3) {
+		t.Errorf("Zeroth power is not the identity")
+	}
+	if p := adj.Power(1); !p.Test(0, 1) || p.Test(0, 0) {
+		t.Errorf("Wrong first power")
+	} else if p[0].Set(0); adj.Test(0, 0) {
+		t.Errorf("First power shares storage with the matrix")
+	}
+
+	// The parallel edges from a to b make the cycle weight 2
+	unit, _ := index.SparseAdjacency(MatrixOptions{Order: []Node{a, b, c}})
+	if r := unit.SpectralRadius(1000, 1e-12); math.Abs(r-math.Cbrt(2)) > 1e-6 {
+		t.Errorf("Expected spectral radius 2^(1/3), got %v", r)
+	}
+	Connect(b, a, NewBasicEdge(nil, nil))
+	Connect(c, b, NewBasicEdge(nil, nil))
+	Connect(a, c, NewBasicEdge(nil, nil))
+	unit, _ = g.GetIndex().SparseAdjacency(MatrixOptions{Order: []Node{b, c}})
+	if r := unit.SpectralRadius(1000, 1e-12); math.Abs(r-1) > 1e-6 {
+		t.Errorf("Expected spectral radius 1, got %v", r)
+	}
+}
+
+type binaryTestNode struct {
+	NodeHeader
+	Owner string
+}
+
+type binaryTestPayload struct {
+	Weight float64
+	Tags   []string
+}
+
+func TestBinaryEncoding(t *testing.T) {
+	gob.Register(binaryTestPayload{})
+	g := New()
+	a := &binaryTestNode{Owner: "x"}
+	a.SetLabel("a")
+	b := &binaryTestNode{Owner: "y"}
+	b.SetLabel([]string{"p", "q"})
+	c := &binaryTestNode{}
+	g.AddNode(a)
+	g.AddNode(c)
+	Connect(a, b, NewBasicEdge("e", binaryTestPayload{Weight: 1.5, Tags: []string{"t"}}))
+	Connect(b, a, NewBasicEdge("e", nil))
+	Connect(b, b, NewBasicEdge(1, 2))
+
+	buf := bytes.Buffer{}
+	enc := NewEncoder(&buf)
+	enc.NodeData = func(n Node) interface{} { return n.(*binaryTestNode).Owner }
+	if err := enc.Encode(g); err != nil {
+		t.Fatal(err)
+	}
+
+	g2 := New()
+	dec := NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.NewNode = func(label, data interface{}) (Node, error) {
+		n := &binaryTestNode{}
+		n.SetLabel(label)
+		if data != nil {
+			n.Owner = data.(string)
+		}
+		return n, nil
+	}
+	if err := dec.Decode(g2); err != nil {
+		t.Fatal(err)
+	}
+	if !CheckIsomorphism(g.GetIndex(), g2.GetIndex(), func(n1, n2 Node) bool {
+		return LabelsEqual(n1.GetLabel(), n2.GetLabel()) && n1.ID() == n2.ID() && n1.(*binaryTestNode).Owner == n2.(*binaryTestNode).Owner
+	}, func(e1, e2 Edge) bool {
+		return LabelsEqual(e1.GetLabel(), e2.GetLabel()) && reflect.DeepEqual(e1.(*BasicEdge).Payload, e2.(*BasicEdge).Payload)
+	}) {
+		t.Errorf("Decoded graph is different")
+	}
+	if len(g2.roots()) != 2 {
+		t.Errorf("Expected 2 roots, got %d", len(g2.roots()))
+	}
+	if n := g2.NodeByID(c.ID()); n == nil || n.GetLabel() != nil {
+		t.Errorf("Isolated root node not decoded")
+	}
+
+	// Roots are the roots of the encoded graph, not of the graph
+	// owning the nodes
+	owner := New()
+	r, s := NewBasicNode("r", nil), NewBasicNode("s", nil)
+	owner.AddNode(NewBasicNode("o", nil))
+	owner.Connect(owner.roots()[0], r, NewBasicEdge(nil, nil))
+	g3 := New()
+	g3.AddNode(r)
+	Connect(r, s, NewBasicEdge(nil, nil))
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(g3); err != nil {
+		t.Fatal(err)
+	}
+	g4 := New()
+	if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(g4); err != nil {
+		t.Fatal(err)
+	}
+	if len(g4.roots()) != 1 || g4.NodeCount() != 2 {
+		t.Errorf("Wrong decoded graph: %d roots, %d nodes", len(g4.roots()), g4.NodeCount())
+	}
+
+	if err := NewEncoder(&buf).EncodeEdge(NewBasicEdge(nil, nil)); err != ErrEdgeNotConnected {
+		t.Errorf("Expected ErrEdgeNotConnected, got %v", err)
+	}
+
+	// A label that cannot be marshaled leaves the output valid
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	enc.Codec = failingCodec{Codec: NewGobCodec(), fail: "bad"}
+	if err := enc.EncodeNode(NewBasicNode("bad", nil)); err == nil {
+		t.Errorf("Expected marshal error")
+	}
+	// So does data that cannot be marshaled
+	if err := enc.EncodeNode(NewBasicNode(nil, "bad")); err == nil {
+		t.Errorf("Expected marshal error")
+	}
+	good, other := NewBasicNode("good", nil), NewBasicNode(nil, nil)
+	Connect(good, other, NewBasicEdge(nil, "bad"))
+	if err := enc.EncodeEdge(good.Out().All()[0]); err == nil {
+		t.Errorf("Expected marshal error")
+	}
+	enc.Flush()
+	dec = NewDecoder(bytes.NewReader(buf.Bytes()))
+	if err := dec.Decode(New()); err != nil || len(dec.nodes) != 2 || dec.nodes[0].GetLabel() != "good" {
+		t.Errorf("Wrong output after marshal error: %v", err)
+	}
+
+	if err := NewDecoder(bytes.NewReader([]byte("nope!"))).Decode(New()); err != ErrInvalidFormat {
+		t.Errorf("Expected invalid format, got %v", err)
+	}
+	if err := NewDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-3])).Decode(New()); err == nil {
+		t.Errorf("Expected error for truncated input")
+	}
+}
+
+// failingCodec fails to marshal the given value
+type failingCodec struct {
+	Codec
+	fail interface{}
+}
+
+func (c failingCodec) Marshal(v interface{}) ([]byte, error) {
+	if v == c.fail {
+		return nil, errors.New("cannot marshal")
+	}
+	return c.Codec.Marshal(v)
+}
+
+func BenchmarkBinaryEncoding(b *testing.B) {
+	g := New()
+	nodes := make([]*BasicNode, 10000)
+	for i := range nodes {
+		nodes[i] = NewBasicNode(i%100, i)
+		g.AddNode(nodes[i])
+	}
+	for i := range nodes {
+		for j := 1; j <= 5; j++ {
+			Connect(nodes[i], nodes[(i*7+j)%len(nodes)], NewBasicEdge(j, float64(i)))
+		}
+	}
+	buf := bytes.Buffer{}
+	b.Run("Encode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			if err := NewEncoder(&buf).Encode(g); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Decode", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(New()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDiff(t *testing.T) {
+	build := func(nodes map[string]interface{}, edges [][4]interface{}) *Graph {
+		g := New()
+		m := make(map[string]*BasicNode)
+		for label, payload := range nodes {
+			m[label] = NewBasicNode(label, payload)
+			g.AddNode(m[label])
+		}
+		for _, e := range edges {
+			Connect(m[e[0].(string)], m[e[1].(string)], NewBasicEdge(e[2], e[3]))
+		}
+		return g
+	}
+	a := build(map[string]interface{}{"x": 1, "y": nil, "z": nil, "w": nil}, [][4]interface{}{
+		{"x", "y", "l1", 1},
+		{"x", "y", "l1", 2},
+		{"y", "z", "l2", nil},
+		{"w", "x", "l1", nil},
+	})
+	b := build(map[string]interface{}{"x": 2, "y": nil, "z": nil, "v": nil}, [][4]interface{}{
+		{"x", "y", "l1", 3},
+		{"x", "y", "l1", 1},
+		{"z", "v", "l3", nil},
+	})
+	key := func(n Node) interface{} { return n.GetLabel() }
+	delta := Diff(a.GetIndex(), b.GetIndex(), key)
+	if len(delta.AddedNodes) != 1 || delta.AddedNodes[0].Key != "v" || !delta.AddedNodes[0].Root {
+		t.Errorf("Wrong added nodes: %v", delta.AddedNodes)
+	}
+	if len(delta.RemovedNodes) != 1 || delta.RemovedNodes[0].Key != "w" {
+		t.Errorf("Wrong removed nodes: %v", delta.RemovedNodes)
+	}
+	if len(delta.ChangedNodes) != 1 || delta.ChangedNodes[0].OldPayload != 1 || delta.ChangedNodes[0].Payload != 2 {
+		t.Errorf("Wrong changed nodes: %v", delta.ChangedNodes)
+	}
+	if len(delta.ChangedEdges) != 1 || delta.ChangedEdges[0].OldPayload != 2 || delta.ChangedEdges[0].Payload != 3 {
+		t.Errorf("Wrong changed edges: %v", delta.ChangedEdges)
+	}
+	if len(delta.AddedEdges) != 1 || delta.AddedEdges[0].Label != "l3" {
+		t.Errorf("Wrong added edges: %v", delta.AddedEdges)
+	}
+	if len(delta.RemovedEdges) != 2 {
+		t.Errorf("Wrong removed edges: %v", delta.RemovedEdges)
+	}
+	if !Diff(a.GetIndex(), a.GetIndex(), key).IsEmpty() {
+		t.Errorf("Expected empty diff")
+	}
+
+	c := New()
+	CopyGraph(c, a, func(n Node) Node { return NewBasicNode(n.GetLabel(), n.(*BasicNode).Payload) },
+		func(e Edge) Edge { return NewBasicEdge(e.GetLabel(), e.(*BasicEdge).Payload) })
+	if err := Apply(c, delta); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(c.GetIndex(), b.GetIndex(), key); !d.IsEmpty() {
+		t.Errorf("Patched graph is different:\n%s", d)
+	}
+	if !CheckIsomorphism(c.GetIndex(), b.GetIndex(), func(n1, n2 Node) bool {
+		return n1.GetLabel() == n2.GetLabel() && n1.(*BasicNode).Payload == n2.(*BasicNode).Payload
+	}, func(e1, e2 Edge) bool {
+		return e1.GetLabel() == e2.GetLabel() && e1.(*BasicEdge).Payload == e2.(*BasicEdge).Payload
+	}) {
+		t.Errorf("Patched graph is not isomorphic")
+	}
+	if err := Apply(c, delta); !errors.Is(err, ErrDeltaConflict) {
+		t.Errorf("Expected conflict, got %v", err)
+	}
+
+	// x is a root only in b, and is accessible in a only through the
+	// removed edge
+	a = build(map[string]interface{}{"r": nil}, nil)
+	x := NewBasicNode("x", nil)
+	Connect(a.roots()[0], x, NewBasicEdge("l", nil))
+	b = build(map[string]interface{}{"r": nil, "x": nil}, nil)
+	delta = Diff(a.GetIndex(), b.GetIndex(), key)
+	if len(delta.AddedRoots) != 1 || delta.AddedRoots[0] != "x" || len(delta.RemovedRoots) != 0 {
+		t.Errorf("Wrong root changes: %v %v", delta.AddedRoots, delta.RemovedRoots)
+	}
+	if err := Apply(a, delta); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(a.GetIndex(), b.GetIndex(), key); !d.IsEmpty() || a.NodeCount() != 2 {
+		t.Errorf("Patched graph is different:\n%s", d)
+	}
+	// Reverse the change
+	a = build(map[string]interface{}{"r": nil}, nil)
+	Connect(a.roots()[0], NewBasicNode("x", nil), NewBasicEdge("l", nil))
+	delta = Diff(b.GetIndex(), a.GetIndex(), key)
+	if len(delta.RemovedRoots) != 1 || delta.RemovedRoots[0] != "x" {
+		t.Errorf("Wrong removed roots: %v", delta.RemovedRoots)
+	}
+	if err := Apply(b, delta); err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(b.GetIndex(), a.GetIndex(), key); !d.IsEmpty() || len(b.roots()) != 1 {
+		t.Errorf("Patched graph is different:\n%s", d)
+	}
+
+	// Removed nodes connected to each other are removed in one pass
+	a = build(map[string]interface{}{"p": nil, "q": nil, "s": nil}, [][4]interface{}{
+		{"p", "q", "l", nil},
+		{"q", "p", "l", nil},
+		{"s", "p", "l", nil},
+	})
+	b = build(map[string]interface{}{"s": nil}, nil)
+	if err := Apply(a, Diff(a.GetIndex(), b.GetIndex(), key)); err != nil {
+		t.Fatal(err)
+	}
+	if a.NodeCount() != 1 || a.EdgeCount() != 0 {
+		t.Errorf("Wrong counts after removing nodes: %d %d", a.NodeCount(), a.EdgeCount())
+	}
+}
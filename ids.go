@@ -0,0 +1,85 @@
+package digraph
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrDuplicateID is returned when a node is added to a graph that
+// already has a different node with the same ID. This only happens if
+// the IDs are set explicitly, for instance by decoding the same graph
+// twice.
+var ErrDuplicateID = errors.New("duplicate node ID")
+
+// lastNodeID is the last assigned node ID. IDs are assigned from a
+// process-wide counter, so nodes keep unique IDs when they are added
+// to other graphs.
+var lastNodeID uint64
+
+// setNodeID sets the ID of a node explicitly, and makes sure the ID is
+// not assigned to other nodes
+func setNodeID(hdr *NodeHeader, id uint64) {
+	hdr.id = id
+	for {
+		last := atomic.LoadUint64(&lastNodeID)
+		if last >= id || atomic.CompareAndSwapUint64(&lastNodeID, last, id) {
+			return
+		}
+	}
+}
+
+// ID returns the ID of the node, or 0 if the node is not assigned an
+// ID yet. A node is assigned an ID when it is added to, or discovered
+// by a graph. IDs are unique within the process. The ID does not
+// change after it is assigned.
+func (hdr *NodeHeader) ID() uint64 {
+	return hdr.id
+}
+
+// register assigns an ID to the node if it does not have one, and
+// records the node by its ID. If the graph has another node with the
+// same ID, the node is not recorded. Returns false in that case.
+func (g *Graph) register(node Node) bool {
+	hdr := node.getNodeHeader()
+	if hdr.id == 0 {
+		hdr.id = atomic.AddUint64(&lastNodeID, 1)
+	}
+	if g.byID == nil {
+		g.byID = make(map[uint64]Node)
+	}
+	if existing, ok := g.byID[hdr.id]; ok {
+		return existing == node
+	}
+	g.byID[hdr.id] = node
+	return true
+}
+
+// unregister removes the node from the ID lookup table
+func (g *Graph) unregister(node Node) {
+	id := node.ID()
+	if g.byID[id] == node {
+		delete(g.byID, id)
+	}
+}
+
+// AssignIDs assigns IDs to all nodes accessible in the graph that do
+// not have one, and records them for NodeByID. Nodes whose IDs
+// conflict with the IDs of other nodes of the graph are not recorded.
+func (g *Graph) AssignIDs() {
+	IterateGraph(g, func(node Node) bool {
+		g.register(node)
+		return true
+	}, func(Edge) bool { return true })
+}
+
+// NodeByID returns the node with the given ID, or nil if there is no
+// such node. If the node is not known to the graph, the graph is
+// traversed to assign IDs to the nodes that were connected without the
+// graph's knowledge.
+func (g *Graph) NodeByID(id uint64) Node {
+	if node, ok := g.byID[id]; ok {
+		return node
+	}
+	g.AssignIDs()
+	return g.byID[id]
+}
@@ -7,7 +7,8 @@ package digraph
 type Index struct {
 	g *Graph
 
-	allNodes        []Node
+	allNodes []Node
+	// allNodesByLabel and incomingEdgesByLabel are keyed by LabelKey
 	allNodesByLabel map[interface{}][]Node
 
 	incomingEdges        map[Node][]Edge
@@ -19,12 +20,13 @@ func (g *Graph) GetIndex() *Index {
 	return &Index{g: g}
 }
 
-// NodesSlice returns all accessible nodes as a slice
+// NodesSlice returns all accessible nodes as a slice. Nodes are
+// returned in the order they are visited by IterateGraph
 func (index *Index) NodesSlice() []Node {
 	if index.allNodes == nil {
 		index.allNodes = make([]Node, 0)
 		seen := map[Node]struct{}{}
-		for node := range index.g.nodes {
+		for _, node := range index.g.roots() {
 			IterateUnique(node, func(n Node) bool {
 				index.allNodes = append(index.allNodes, n)
 				return true
@@ -44,14 +46,15 @@ func (index *Index) NodesByLabelSlice(label interface{}) []Node {
 	if index.allNodesByLabel == nil {
 		index.allNodesByLabel = make(map[interface{}][]Node)
 		seen := map[Node]struct{}{}
-		for node := range index.g.nodes {
+		for _, node := range index.g.roots() {
 			IterateUnique(node, func(n Node) bool {
-				index.allNodesByLabel[n.GetLabel()] = append(index.allNodesByLabel[n.GetLabel()], n)
+				key := LabelKey(n.GetLabel())
+				index.allNodesByLabel[key] = append(index.allNodesByLabel[key], n)
 				return true
 			}, func(e Edge) bool { return true }, seen)
 		}
 	}
-	return index.allNodesByLabel[label]
+	return index.allNodesByLabel[LabelKey(label)]
 }
 
 // NodesByLabel returns nodes by label
@@ -85,7 +88,7 @@ func (index *Index) InSlice(node Node) []Edge {
 	if index.incomingEdges == nil {
 		index.incomingEdges = make(map[Node][]Edge)
 		seen := map[Node]struct{}{}
-		for node := range index.g.nodes {
+		for _, node := range index.g.roots() {
 			IterateUnique(node, func(n Node) bool {
 				return true
 			}, func(e Edge) bool {
@@ -110,7 +113,7 @@ func (index *Index) InWithSlice(node Node, label interface{}) []Edge {
 	if index.incomingEdgesByLabel == nil {
 		index.incomingEdgesByLabel = make(map[Node]map[interface{}][]Edge)
 		seen := map[Node]struct{}{}
-		for node := range index.g.nodes {
+		for _, node := range index.g.roots() {
 			IterateUnique(node, func(n Node) bool {
 				return true
 			}, func(e Edge) bool {
@@ -119,7 +122,8 @@ func (index *Index) InWithSlice(node Node, label interface{}) []Edge {
 					m = make(map[interface{}][]Edge)
 					index.incomingEdgesByLabel[e.GetTo()] = m
 				}
-				m[e.GetLabel()] = append(m[e.GetLabel()], e)
+				key := edgeKey(e)
+				m[key] = append(m[key], e)
 				return true
 			},
 				seen)
@@ -127,7 +131,7 @@ func (index *Index) InWithSlice(node Node, label interface{}) []Edge {
 	}
 	m := index.incomingEdgesByLabel[node]
 	if m != nil {
-		return m[label]
+		return m[LabelKey(label)]
 	}
 	return nil
 }
@@ -137,3 +141,43 @@ func (index *Index) InWithSlice(node Node, label interface{}) []Edge {
 func (index *Index) InWith(node Node, label interface{}) Edges {
 	return Edges{&edgeSliceIterator{index.InWithSlice(node, label)}}
 }
+
+// Relabel changes the label of an edge, and updates the index for
+// the new label. The edge keeps its position among the outgoing edges
+// of its source node, and the incoming edges of its target node.
+func (index *Index) Relabel(edge Edge, label interface{}) {
+	oldKey := edgeKey(edge)
+	Relabel(edge, label)
+	to := edge.GetTo()
+	if index.incomingEdgesByLabel == nil || to == nil {
+		return
+	}
+	m := index.incomingEdgesByLabel[to]
+	if m == nil {
+		return
+	}
+	if x := m[oldKey]; x != nil {
+		w := 0
+		for _, e := range x {
+			if e != edge {
+				x[w] = e
+				w++
+			}
+		}
+		if w == 0 {
+			delete(m, oldKey)
+		} else {
+			m[oldKey] = x[:w]
+		}
+	}
+	// Rebuild the incoming edges with the new label to keep them in
+	// the order of all incoming edges
+	key := LabelKey(label)
+	x := make([]Edge, 0, len(m[key])+1)
+	for _, e := range index.InSlice(to) {
+		if edgeKey(e) == key {
+			x = append(x, e)
+		}
+	}
+	m[key] = x
+}
@@ -1,6 +1,8 @@
 package digraph
 
-import ()
+import (
+	"sort"
+)
 
 // Nodes is a convenience wrapper aroung NodeItr for chained methods
 type Nodes struct {
@@ -16,6 +18,15 @@ func (n Nodes) All() []Node {
 	return ret
 }
 
+// NextOK returns the next node and true if there is one. If there are
+// no more nodes, returns nil and false instead of panicking
+func (n Nodes) NextOK() (Node, bool) {
+	if !n.HasNext() {
+		return nil, false
+	}
+	return n.Next(), true
+}
+
 // NodeIterator iterates through a list of nodes
 type NodeIterator interface {
 	// Returns if there are more nodes to go through
@@ -73,6 +84,20 @@ func (n Nodes) Select(predicate func(Node) bool) Nodes {
 	return Nodes{&filterNodes{source: n, filter: predicate}}
 }
 
+// SortedBy returns the remaining nodes sorted by the less
+// function. Nodes that are equal keep their order.
+func (n Nodes) SortedBy(less func(a, b Node) bool) Nodes {
+	nodes := n.All()
+	sort.SliceStable(nodes, func(i, j int) bool { return less(nodes[i], nodes[j]) })
+	return NewNodeSliceIterator(nodes...)
+}
+
+// NodesByID is a less function that orders nodes by their IDs. To be
+// used with SortedBy
+func NodesByID(a, b Node) bool {
+	return a.ID() < b.ID()
+}
+
 // NewNodeSliceIterator returns a Nodes for the given array of nodes
 func NewNodeSliceIterator(nodes ...Node) Nodes { return Nodes{&nodeSliceIterator{Nodes: nodes}} }
 
@@ -139,8 +164,9 @@ func (n Nodes) Unique() Nodes {
 
 // NodesByLabelPredicate returns a predicate that select nodes by label. This is to be used in Nodes.Select
 func NodesByLabelPredicate(id interface{}) func(Node) bool {
+	key := LabelKey(id)
 	return func(n Node) bool {
-		return n.GetLabel() == id
+		return LabelKey(n.GetLabel()) == key
 	}
 }
 
@@ -158,6 +184,15 @@ func (e Edges) All() []Edge {
 	return ret
 }
 
+// NextOK returns the next edge and true if there is one. If there are
+// no more edges, returns nil and false instead of panicking
+func (e Edges) NextOK() (Edge, bool) {
+	if !e.HasNext() {
+		return nil, false
+	}
+	return e.Next(), true
+}
+
 type edgeNodeSelector struct {
 	source     EdgeIterator
 	selectNode func(Edge) Node
@@ -232,3 +267,11 @@ func (a *filterEdges) Next() Edge {
 func (e Edges) Select(predicate func(Edge) bool) Edges {
 	return Edges{&filterEdges{source: e, filter: predicate}}
 }
+
+// SortedBy returns the remaining edges sorted by the less
+// function. Edges that are equal keep their order.
+func (e Edges) SortedBy(less func(a, b Edge) bool) Edges {
+	edges := e.All()
+	sort.SliceStable(edges, func(i, j int) bool { return less(edges[i], edges[j]) })
+	return NewEdges(edges...)
+}
@@ -0,0 +1,291 @@
+package digraph
+
+import (
+	"errors"
+)
+
+var (
+	// ErrTransactionActive is returned when an operation that requires
+	// no active transaction is called during a transaction
+	ErrTransactionActive = errors.New("transaction is active")
+	// ErrNoTransaction is returned when Commit or Rollback is called
+	// without an active transaction
+	ErrNoTransaction = errors.New("no active transaction")
+	// ErrNothingToUndo is returned when Undo is called with an empty
+	// undo stack
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrNothingToRedo is returned when Redo is called with an empty
+	// redo stack
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// journalOp is a recorded graph modification
+type journalOp struct {
+	do   func()
+	undo func()
+}
+
+// journalEntry is a group of operations that are undone and redone
+// together
+type journalEntry []journalOp
+
+func (entry journalEntry) undo() {
+	for i := len(entry) - 1; i >= 0; i-- {
+		entry[i].undo()
+	}
+}
+
+func (entry journalEntry) redo() {
+	for _, op := range entry {
+		op.do()
+	}
+}
+
+// Journal records the modifications of a graph so they can be undone
+// and redone. All modifications must be done through the journal for
+// undo and redo to work correctly.
+//
+// Modifications made between Begin and Commit are recorded as a
+// single entry, and can be rolled back. Modifications made outside a
+// transaction are recorded as one entry each.
+//
+// Undo reconnects the original edge objects at their original
+// positions among the outgoing edges of their source nodes, and
+// notifies the listeners of the graph.
+type Journal struct {
+	g *Graph
+
+	undo []journalEntry
+	redo []journalEntry
+
+	inTx bool
+	tx   journalEntry
+}
+
+// NewJournal returns a new journal for the graph
+func NewJournal(g *Graph) *Journal {
+	return &Journal{g: g}
+}
+
+// Graph returns the graph of the journal
+func (j *Journal) Graph() *Graph {
+	return j.g
+}
+
+func (j *Journal) record(op journalOp) {
+	if j.inTx {
+		j.tx = append(j.tx, op)
+		return
+	}
+	j.undo = append(j.undo, journalEntry{op})
+	j.redo = nil
+}
+
+// Begin starts a new transaction
+func (j *Journal) Begin() error {
+	if j.inTx {
+		return ErrTransactionActive
+	}
+	j.inTx = true
+	j.tx = nil
+	return nil
+}
+
+// Commit ends the current transaction, and records all modifications
+// made in the transaction as a single undo entry
+func (j *Journal) Commit() error {
+	if !j.inTx {
+		return ErrNoTransaction
+	}
+	j.inTx = false
+	if len(j.tx) > 0 {
+		j.undo = append(j.undo, j.tx)
+		j.redo = nil
+	}
+	j.tx = nil
+	return nil
+}
+
+// Rollback undoes all modifications made in the current transaction
+// and ends the transaction
+func (j *Journal) Rollback() error {
+	if !j.inTx {
+		return ErrNoTransaction
+	}
+	j.tx.undo()
+	j.inTx = false
+	j.tx = nil
+	return nil
+}
+
+// InTransaction returns true if there is an active transaction
+func (j *Journal) InTransaction() bool {
+	return j.inTx
+}
+
+// CanUndo returns true if there are entries to undo
+func (j *Journal) CanUndo() bool {
+	return len(j.undo) > 0
+}
+
+// CanRedo returns true if there are entries to redo
+func (j *Journal) CanRedo() bool {
+	return len(j.redo) > 0
+}
+
+// Undo undoes the last entry
+func (j *Journal) Undo() error {
+	if j.inTx {
+		return ErrTransactionActive
+	}
+	if len(j.undo) == 0 {
+		return ErrNothingToUndo
+	}
+	entry := j.undo[len(j.undo)-1]
+	j.undo = j.undo[:len(j.undo)-1]
+	entry.undo()
+	j.redo = append(j.redo, entry)
+	return nil
+}
+
+// Redo redoes the last undone entry
+func (j *Journal) Redo() error {
+	if j.inTx {
+		return ErrTransactionActive
+	}
+	if len(j.redo) == 0 {
+		return ErrNothingToRedo
+	}
+	entry := j.redo[len(j.redo)-1]
+	j.redo = j.redo[:len(j.redo)-1]
+	entry.redo()
+	j.undo = append(j.undo, entry)
+	return nil
+}
+
+// AddNode adds a node to the graph
+func (j *Journal) AddNode(node Node) error {
+	_, exists := j.g.nodes[node]
+	if err := j.g.TryAddNode(node); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	j.record(journalOp{
+		do:   func() { j.g.AddNode(node) },
+		undo: func() { j.g.removeRoot(node) },
+	})
+	return nil
+}
+
+// Connect connects two nodes with the given edge, enforcing the graph
+// policy
+func (j *Journal) Connect(from, to Node, edge Edge) error {
+	if err := j.g.Connect(from, to, edge); err != nil {
+		return err
+	}
+	j.record(journalOp{
+		do:   func() { Connect(from, to, edge) },
+		undo: func() { j.g.Disconnect(edge) },
+	})
+	return nil
+}
+
+// Disconnect removes an edge. Returns ErrNilEdge if edge is nil
+func (j *Journal) Disconnect(edge Edge) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	from, to := edge.GetFrom(), edge.GetTo()
+	if from == nil {
+		return nil
+	}
+	order := from.Out().All()
+	j.g.Disconnect(edge)
+	j.record(journalOp{
+		do: func() { j.g.Disconnect(edge) },
+		undo: func() {
+			j.reconnect(from, to, edge)
+			from.getNodeHeader().restoreOutgoingEdges(order)
+		},
+	})
+	return nil
+}
+
+// reconnect connects a disconnected edge again. A source node that
+// was removed from the graph is owned by the graph again, so the
+// edge is counted and reported as if it was connected using
+// Graph.Connect. The policy is not checked, because the edge was in
+// the graph before.
+func (j *Journal) reconnect(from, to Node, edge Edge) {
+	if from.getNodeHeader().graph == nil {
+		j.g.own(from)
+	}
+	Connect(from, to, edge)
+}
+
+// SetLabel sets the label of a node
+func (j *Journal) SetLabel(node Node, label interface{}) {
+	old := node.GetLabel()
+	j.g.SetLabel(node, label)
+	j.record(journalOp{
+		do:   func() { j.g.SetLabel(node, label) },
+		undo: func() { j.g.SetLabel(node, old) },
+	})
+}
+
+// Relabel changes the label of an edge. Returns ErrNilEdge if edge is
+// nil
+func (j *Journal) Relabel(edge Edge, label interface{}) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	old := edge.GetLabel()
+	j.g.Relabel(edge, label)
+	j.record(journalOp{
+		do:   func() { j.g.Relabel(edge, label) },
+		undo: func() { j.g.Relabel(edge, old) },
+	})
+	return nil
+}
+
+// RemoveNode removes a node and all its incoming and outgoing edges
+// from the graph
+func (j *Journal) RemoveNode(node Node) {
+	_, root := j.g.nodes[node]
+	type endpoints struct {
+		from, to Node
+		edge     Edge
+	}
+	var removed []endpoints
+	// orders keeps the outgoing edges of the source nodes of the
+	// removed edges in their original order
+	orders := map[Node][]Edge{node: node.Out().All()}
+	for _, edge := range orders[node] {
+		removed = append(removed, endpoints{from: node, to: edge.GetTo(), edge: edge})
+	}
+	for _, edge := range j.g.GetIndex().InSlice(node) {
+		if from := edge.GetFrom(); from != node {
+			removed = append(removed, endpoints{from: from, to: node, edge: edge})
+			if _, ok := orders[from]; !ok {
+				orders[from] = from.Out().All()
+			}
+		}
+	}
+	j.g.RemoveNode(node)
+	j.record(journalOp{
+		do: func() { j.g.RemoveNode(node) },
+		undo: func() {
+			if root {
+				j.g.AddNode(node)
+			}
+			for _, e := range removed {
+				j.reconnect(e.from, e.to, e.edge)
+			}
+			for from, edges := range orders {
+				from.getNodeHeader().restoreOutgoingEdges(edges)
+			}
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package digraph
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// LabelKeyer is implemented by labels that should not be compared
+// using ==. Labels are compared and indexed by the key returned by
+// LabelKey, which must be comparable. Two labels are equal if their
+// keys are equal.
+//
+// Labels that are not comparable, such as slices, maps, or structs
+// containing them, and that do not implement LabelKeyer are keyed by
+// their type and formatted value.
+type LabelKeyer interface {
+	LabelKey() interface{}
+}
+
+// derivedKey is the key of a label that is not comparable
+type derivedKey struct {
+	key string
+}
+
+// LabelKey returns the key used to compare and index the label. The
+// keys of edge labels are computed when the edges are connected or
+// relabeled, so labels must not be modified while their edges are
+// connected.
+func LabelKey(label interface{}) interface{} {
+	switch l := label.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64, complex64, complex128:
+		return label
+	case LabelKeyer:
+		return l.LabelKey()
+	}
+	if isComparable(label) {
+		return label
+	}
+	return derivedKey{key: fmt.Sprintf("%T %#v", label, label)}
+}
+
+// LabelsEqual returns true if the two labels have the same key
+func LabelsEqual(label1, label2 interface{}) bool {
+	return LabelKey(label1) == LabelKey(label2)
+}
+
+// comparability describes if the values of a type can be compared
+// using ==
+type comparability int
+
+const (
+	notComparable comparability = iota
+	alwaysComparable
+	// maybeComparable types contain interfaces, which may hold values
+	// that are not comparable
+	maybeComparable
+)
+
+// comparabilities caches the comparability of types
+var comparabilities sync.Map
+
+func typeComparability(t reflect.Type) comparability {
+	if !t.Comparable() {
+		return notComparable
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		return maybeComparable
+	case reflect.Array:
+		return typeComparability(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if c := typeComparability(t.Field(i).Type); c != alwaysComparable {
+				return c
+			}
+		}
+	}
+	return alwaysComparable
+}
+
+// isComparable returns true if v can be compared using ==
+func isComparable(v interface{}) (ok bool) {
+	t := reflect.TypeOf(v)
+	c, found := comparabilities.Load(t)
+	if !found {
+		c = typeComparability(t)
+		comparabilities.Store(t, c)
+	}
+	switch c.(comparability) {
+	case notComparable:
+		return false
+	case alwaysComparable:
+		return true
+	}
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	_ = v == v
+	return true
+}
@@ -0,0 +1,496 @@
+package digraph
+
+import (
+	"sort"
+)
+
+// Point is a point in layout coordinates
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// LayoutOptions controls the layered layout of a graph. Zero values
+// are replaced by defaults.
+type LayoutOptions struct {
+	// NodeSize returns the width and height of a node. If nil, all
+	// nodes are 80x30
+	NodeSize func(Node) (width, height float64)
+	// LayerSpacing is the vertical space between layers. Default is 50
+	LayerSpacing float64
+	// NodeSpacing is the minimum horizontal space between nodes of the
+	// same layer. Default is 20
+	NodeSpacing float64
+	// Margin is the space around the drawing. Default is 10
+	Margin float64
+	// Iterations is the number of crossing minimization sweeps.
+	// Default is 8
+	Iterations int
+	// NodeLess, if set, orders the nodes before layout. Otherwise the
+	// order of Graph.GetAllNodes is used
+	NodeLess func(a, b Node) bool
+	// Filter, if set, excludes the nodes for which it returns false,
+	// and their edges
+	Filter func(Node) bool
+}
+
+func (opts LayoutOptions) withDefaults() LayoutOptions {
+	if opts.NodeSize == nil {
+		opts.NodeSize = func(Node) (float64, float64) { return 80, 30 }
+	}
+	if opts.LayerSpacing == 0 {
+		opts.LayerSpacing = 50
+	}
+	if opts.NodeSpacing == 0 {
+		opts.NodeSpacing = 20
+	}
+	if opts.Margin == 0 {
+		opts.Margin = 10
+	}
+	if opts.Iterations == 0 {
+		opts.Iterations = 8
+	}
+	return opts
+}
+
+// NodeLayout is the position of a node. X and Y are the center of the
+// node.
+type NodeLayout struct {
+	Node          Node
+	X, Y          float64
+	Width, Height float64
+	// Layer is the layer of the node, starting from 0 at the top
+	Layer int
+	// Order is the position of the node in its layer, starting from 0
+	// at the left. Positions of dummy nodes are counted.
+	Order int
+}
+
+// EdgeLayout is the route of an edge. Points start at the source node
+// and end at the target node.
+type EdgeLayout struct {
+	Edge   Edge
+	Points []Point
+	// Reversed is true if the edge was reversed to break a cycle, so it
+	// points upwards
+	Reversed bool
+}
+
+// Layout is a layered drawing of a graph. Layers are placed top to
+// bottom.
+type Layout struct {
+	Nodes         []NodeLayout
+	Edges         []EdgeLayout
+	Width, Height float64
+	Layers        int
+	byNode        map[Node]int
+}
+
+// NodeLayout returns the layout of the node
+func (l *Layout) NodeLayout(node Node) (NodeLayout, bool) {
+	if i, ok := l.byNode[node]; ok {
+		return l.Nodes[i], true
+	}
+	return NodeLayout{}, false
+}
+
+// layoutVertex is a node, or a dummy node of a long edge
+type layoutVertex struct {
+	node     Node
+	layer    int
+	order    int
+	x, y     float64
+	w, h     float64
+	up, down []int
+}
+
+type layoutArc struct {
+	from, to int
+	edge     Edge
+	reversed bool
+	chain    []int
+}
+
+// LayoutGraph computes a layered layout of the graph using the
+// Sugiyama method. Cycles are broken by reversing the back edges of a
+// depth-first search, nodes are assigned to layers by longest path,
+// long edges are split by dummy nodes, crossings are reduced by
+// barycenter sweeps, and nodes are placed close to the average
+// position of their neighbors.
+func LayoutGraph(g *Graph, opts LayoutOptions) *Layout {
+	opts = opts.withDefaults()
+	nodes := g.GetAllNodes()
+	if opts.NodeLess != nil {
+		nodes = nodes.SortedBy(opts.NodeLess)
+	}
+	allNodes := nodes.All()
+	if opts.Filter != nil {
+		included := allNodes[:0]
+		for _, node := range allNodes {
+			if opts.Filter(node) {
+				included = append(included, node)
+			}
+		}
+		allNodes = included
+	}
+	index := make(map[Node]int, len(allNodes))
+	verts := make([]*layoutVertex, 0, len(allNodes))
+	for i, node := range allNodes {
+		index[node] = i
+		w, h := opts.NodeSize(node)
+		verts = append(verts, &layoutVertex{node: node, w: w, h: h})
+	}
+	arcs := make([]*layoutArc, 0)
+	out := make([][]*layoutArc, len(allNodes))
+	for i, node := range allNodes {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			if j, ok := index[edge.GetTo()]; ok {
+				arc := &layoutArc{from: i, to: j, edge: edge}
+				arcs = append(arcs, arc)
+				out[i] = append(out[i], arc)
+			}
+		}
+	}
+
+	breakCycles(out)
+	layers := assignLayers(verts, arcs)
+	for _, arc := range arcs {
+		if arc.from == arc.to {
+			continue
+		}
+		upper, lower := arc.from, arc.to
+		if arc.reversed {
+			upper, lower = lower, upper
+		}
+		arc.chain = []int{upper}
+		for l := verts[upper].layer + 1; l < verts[lower].layer; l++ {
+			verts = append(verts, &layoutVertex{layer: l})
+			arc.chain = append(arc.chain, len(verts)-1)
+		}
+		arc.chain = append(arc.chain, lower)
+		for i := 1; i < len(arc.chain); i++ {
+			a, b := arc.chain[i-1], arc.chain[i]
+			verts[a].down = append(verts[a].down, b)
+			verts[b].up = append(verts[b].up, a)
+		}
+	}
+	order := make([][]int, layers)
+	for i, v := range verts {
+		v.order = len(order[v.layer])
+		order[v.layer] = append(order[v.layer], i)
+	}
+
+	reduceCrossings(verts, order, opts.Iterations)
+	assignCoordinates(verts, order, opts)
+
+	ret := &Layout{Layers: layers, byNode: make(map[Node]int, len(allNodes))}
+	for i, node := range allNodes {
+		v := verts[i]
+		ret.byNode[node] = i
+		ret.Nodes = append(ret.Nodes, NodeLayout{Node: node, X: v.x, Y: v.y, Width: v.w, Height: v.h, Layer: v.layer, Order: v.order})
+	}
+	for _, v := range verts {
+		if r := v.x + v.w/2 + opts.Margin; r > ret.Width {
+			ret.Width = r
+		}
+		if b := v.y + v.h/2 + opts.Margin; b > ret.Height {
+			ret.Height = b
+		}
+	}
+	for _, arc := range arcs {
+		e := EdgeLayout{Edge: arc.edge, Reversed: arc.reversed}
+		if arc.from == arc.to {
+			v := verts[arc.from]
+			right, loop := v.x+v.w/2, v.x+v.w/2+opts.NodeSpacing/2
+			e.Points = []Point{{right, v.y - v.h/4}, {loop, v.y - v.h/4}, {loop, v.y + v.h/4}, {right, v.y + v.h/4}}
+			if loop+opts.Margin > ret.Width {
+				ret.Width = loop + opts.Margin
+			}
+		} else {
+			for i, vi := range arc.chain {
+				v := verts[vi]
+				switch i {
+				case 0:
+					e.Points = append(e.Points, Point{v.x, v.y + v.h/2})
+				case len(arc.chain) - 1:
+					e.Points = append(e.Points, Point{v.x, v.y - v.h/2})
+				default:
+					e.Points = append(e.Points, Point{v.x, v.y})
+				}
+			}
+			if arc.reversed {
+				for i, j := 0, len(e.Points)-1; i < j; i, j = i+1, j-1 {
+					e.Points[i], e.Points[j] = e.Points[j], e.Points[i]
+				}
+			}
+		}
+		ret.Edges = append(ret.Edges, e)
+	}
+	return ret
+}
+
+// breakCycles marks the back edges of a depth-first search as
+// reversed. Self loops are not marked.
+func breakCycles(out [][]*layoutArc) {
+	type frame struct {
+		v, next int
+	}
+	state := make([]byte, len(out))
+	for s := range out {
+		if state[s] != 0 {
+			continue
+		}
+		state[s] = 1
+		stack := []frame{{v: s}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next == len(out[top.v]) {
+				state[top.v] = 2
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			arc := out[top.v][top.next]
+			top.next++
+			switch state[arc.to] {
+			case 0:
+				state[arc.to] = 1
+				stack = append(stack, frame{v: arc.to})
+			case 1:
+				arc.reversed = arc.from != arc.to
+			}
+		}
+	}
+}
+
+// assignLayers assigns each node to the layer one below its lowest
+// predecessor, and returns the number of layers
+func assignLayers(verts []*layoutVertex, arcs []*layoutArc) int {
+	succ := make([][]int, len(verts))
+	indegree := make([]int, len(verts))
+	for _, arc := range arcs {
+		if arc.from == arc.to {
+			continue
+		}
+		upper, lower := arc.from, arc.to
+		if arc.reversed {
+			upper, lower = lower, upper
+		}
+		succ[upper] = append(succ[upper], lower)
+		indegree[lower]++
+	}
+	queue := make([]int, 0, len(verts))
+	for i := range verts {
+		if indegree[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	layers := 0
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		if verts[v].layer+1 > layers {
+			layers = verts[v].layer + 1
+		}
+		for _, w := range succ[v] {
+			if verts[v].layer+1 > verts[w].layer {
+				verts[w].layer = verts[v].layer + 1
+			}
+			indegree[w]--
+			if indegree[w] == 0 {
+				queue = append(queue, w)
+			}
+		}
+	}
+	return layers
+}
+
+// reduceCrossings reorders the layers by alternating downward and
+// upward barycenter sweeps, and keeps the order with the fewest
+// crossings
+func reduceCrossings(verts []*layoutVertex, order [][]int, iterations int) {
+	copyOrder := func() [][]int {
+		ret := make([][]int, len(order))
+		for i := range order {
+			ret[i] = append([]int{}, order[i]...)
+		}
+		return ret
+	}
+	best := copyOrder()
+	bestCrossings := countCrossings(verts, order)
+	for it := 0; it < iterations && bestCrossings > 0; it++ {
+		for l := 1; l < len(order); l++ {
+			sortByBarycenter(verts, order[l], func(v *layoutVertex) []int { return v.up })
+		}
+		for l := len(order) - 2; l >= 0; l-- {
+			sortByBarycenter(verts, order[l], func(v *layoutVertex) []int { return v.down })
+		}
+		if c := countCrossings(verts, order); c < bestCrossings {
+			best = copyOrder()
+			bestCrossings = c
+		}
+	}
+	for l := range best {
+		order[l] = best[l]
+		for i, v := range best[l] {
+			verts[v].order = i
+		}
+	}
+}
+
+// sortByBarycenter sorts the layer by the average order of the
+// neighbors of each vertex. Vertices without neighbors keep their
+// position.
+func sortByBarycenter(verts []*layoutVertex, layer []int, neighbors func(*layoutVertex) []int) {
+	bary := make(map[int]float64, len(layer))
+	for _, vi := range layer {
+		v := verts[vi]
+		nb := neighbors(v)
+		if len(nb) == 0 {
+			bary[vi] = float64(v.order)
+			continue
+		}
+		sum := 0
+		for _, n := range nb {
+			sum += verts[n].order
+		}
+		bary[vi] = float64(sum) / float64(len(nb))
+	}
+	sort.SliceStable(layer, func(i, j int) bool { return bary[layer[i]] < bary[layer[j]] })
+	for i, vi := range layer {
+		verts[vi].order = i
+	}
+}
+
+// countCrossings returns the number of edge crossings between
+// adjacent layers
+func countCrossings(verts []*layoutVertex, order [][]int) int {
+	total := 0
+	for l := 0; l+1 < len(order); l++ {
+		type segment struct{ a, b int }
+		segments := make([]segment, 0)
+		for _, vi := range order[l] {
+			for _, w := range verts[vi].down {
+				segments = append(segments, segment{verts[vi].order, verts[w].order})
+			}
+		}
+		sort.Slice(segments, func(i, j int) bool {
+			if segments[i].a == segments[j].a {
+				return segments[i].b < segments[j].b
+			}
+			return segments[i].a < segments[j].a
+		})
+		targets := make([]int, len(segments))
+		for i, s := range segments {
+			targets[i] = s.b
+		}
+		total += countInversions(targets, make([]int, len(targets)))
+	}
+	return total
+}
+
+// countInversions sorts a and returns the number of pairs i<j with
+// a[i]>a[j]
+func countInversions(a, tmp []int) int {
+	if len(a) < 2 {
+		return 0
+	}
+	mid := len(a) / 2
+	n := countInversions(a[:mid], tmp[:mid]) + countInversions(a[mid:], tmp[mid:])
+	i, j, k := 0, mid, 0
+	for i < mid && j < len(a) {
+		if a[j] < a[i] {
+			tmp[k] = a[j]
+			n += mid - i
+			j++
+		} else {
+			tmp[k] = a[i]
+			i++
+		}
+		k++
+	}
+	k += copy(tmp[k:], a[i:mid])
+	copy(tmp[k:], a[j:])
+	copy(a, tmp)
+	return n
+}
+
+// assignCoordinates places the layers top to bottom, and moves the
+// vertices of each layer towards the average position of their
+// neighbors, keeping them apart by NodeSpacing
+func assignCoordinates(verts []*layoutVertex, order [][]int, opts LayoutOptions) {
+	top := opts.Margin
+	for _, layer := range order {
+		height := 0.0
+		for _, vi := range layer {
+			if verts[vi].h > height {
+				height = verts[vi].h
+			}
+		}
+		for _, vi := range layer {
+			verts[vi].y = top + height/2
+		}
+		top += height + opts.LayerSpacing
+	}
+	for _, layer := range order {
+		x := 0.0
+		for i, vi := range layer {
+			v := verts[vi]
+			if i > 0 {
+				prev := verts[layer[i-1]]
+				x += prev.w/2 + opts.NodeSpacing + v.w/2
+			}
+			v.x = x
+		}
+	}
+	place := func(layer []int, neighbors func(*layoutVertex) []int) {
+		desired := make([]float64, len(layer))
+		sumDesired, sumX := 0.0, 0.0
+		for i, vi := range layer {
+			v := verts[vi]
+			desired[i] = v.x
+			if nb := neighbors(v); len(nb) > 0 {
+				sum := 0.0
+				for _, n := range nb {
+					sum += verts[n].x
+				}
+				desired[i] = sum / float64(len(nb))
+			}
+			sumDesired += desired[i]
+		}
+		for i, vi := range layer {
+			v := verts[vi]
+			v.x = desired[i]
+			if i > 0 {
+				prev := verts[layer[i-1]]
+				if minX := prev.x + prev.w/2 + opts.NodeSpacing + v.w/2; v.x < minX {
+					v.x = minX
+				}
+			}
+			sumX += v.x
+		}
+		// Shifting the layer keeps the spacing, and centers it on the
+		// desired positions
+		shift := (sumDesired - sumX) / float64(len(layer))
+		for _, vi := range layer {
+			verts[vi].x += shift
+		}
+	}
+	for it := 0; it < opts.Iterations; it++ {
+		for l := 1; l < len(order); l++ {
+			place(order[l], func(v *layoutVertex) []int { return v.up })
+		}
+		for l := len(order) - 2; l >= 0; l-- {
+			place(order[l], func(v *layoutVertex) []int { return v.down })
+		}
+	}
+	left := 0.0
+	for i, v := range verts {
+		if l := v.x - v.w/2; i == 0 || l < left {
+			left = l
+		}
+	}
+	for _, v := range verts {
+		v.x += opts.Margin - left
+	}
+}
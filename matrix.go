@@ -0,0 +1,335 @@
+package digraph
+
+import (
+	"math"
+	"sort"
+)
+
+// MatrixOptions controls the construction of adjacency matrices
+type MatrixOptions struct {
+	// Order is the order of the rows and columns. Edges to nodes that
+	// are not in Order are ignored. If nil, the nodes of the index are
+	// used in the order returned by Index.NodesSlice
+	Order []Node
+	// Weight returns the weight of an edge. If nil, edges have weight
+	// 1. Weights of parallel edges are added.
+	Weight func(Edge) float64
+}
+
+func (opts MatrixOptions) order(index *Index) ([]Node, map[Node]int) {
+	nodes := opts.Order
+	if nodes == nil {
+		nodes = index.NodesSlice()
+	}
+	ids := make(map[Node]int, len(nodes))
+	for i, node := range nodes {
+		ids[node] = i
+	}
+	return nodes, ids
+}
+
+func (opts MatrixOptions) weight(edge Edge) float64 {
+	if opts.Weight == nil {
+		return 1
+	}
+	return opts.Weight(edge)
+}
+
+// DenseMatrix is a square matrix stored in row-major order
+type DenseMatrix struct {
+	N    int
+	Data []float64
+}
+
+// NewDenseMatrix returns an n x n zero matrix
+func NewDenseMatrix(n int) *DenseMatrix {
+	return &DenseMatrix{N: n, Data: make([]float64, n*n)}
+}
+
+// At returns the element at row i, column j
+func (m *DenseMatrix) At(i, j int) float64 { return m.Data[i*m.N+j] }
+
+// Set sets the element at row i, column j
+func (m *DenseMatrix) Set(i, j int, v float64) { m.Data[i*m.N+j] = v }
+
+// Row returns row i. The returned slice shares the matrix storage
+func (m *DenseMatrix) Row(i int) []float64 { return m.Data[i*m.N : (i+1)*m.N] }
+
+// MulVec returns m*x
+func (m *DenseMatrix) MulVec(x []float64) []float64 {
+	ret := make([]float64, m.N)
+	for i := range ret {
+		sum := 0.0
+		for j, v := range m.Row(i) {
+			sum += v * x[j]
+		}
+		ret[i] = sum
+	}
+	return ret
+}
+
+// InDegreeLaplacian returns D-m where D is the diagonal matrix of the
+// column sums of m. For an adjacency matrix, the column sums are the
+// weighted in-degrees of the nodes.
+func (m *DenseMatrix) InDegreeLaplacian() *DenseMatrix {
+	ret := NewDenseMatrix(m.N)
+	for i := 0; i < m.N; i++ {
+		for j := 0; j < m.N; j++ {
+			v := m.At(i, j)
+			ret.Set(i, j, ret.At(i, j)-v)
+			ret.Set(j, j, ret.At(j, j)+v)
+		}
+	}
+	return ret
+}
+
+// SparseMatrix is a square matrix in compressed sparse row format.
+// The nonzero elements of row i are at positions RowPtr[i] to
+// RowPtr[i+1] of Cols and Values, sorted by column.
+type SparseMatrix struct {
+	N      int
+	RowPtr []int
+	Cols   []int
+	Values []float64
+}
+
+// NNZ returns the number of stored elements
+func (m *SparseMatrix) NNZ() int { return len(m.Cols) }
+
+// Row returns the columns and values of the nonzero elements of row
+// i. The returned slices share the matrix storage
+func (m *SparseMatrix) Row(i int) ([]int, []float64) {
+	return m.Cols[m.RowPtr[i]:m.RowPtr[i+1]], m.Values[m.RowPtr[i]:m.RowPtr[i+1]]
+}
+
+// At returns the element at row i, column j
+func (m *SparseMatrix) At(i, j int) float64 {
+	cols, values := m.Row(i)
+	k := sort.SearchInts(cols, j)
+	if k < len(cols) && cols[k] == j {
+		return values[k]
+	}
+	return 0
+}
+
+// MulVec returns m*x
+func (m *SparseMatrix) MulVec(x []float64) []float64 {
+	ret := make([]float64, m.N)
+	for i := range ret {
+		cols, values := m.Row(i)
+		sum := 0.0
+		for k, j := range cols {
+			sum += values[k] * x[j]
+		}
+		ret[i] = sum
+	}
+	return ret
+}
+
+// Dense returns the matrix as a dense matrix
+func (m *SparseMatrix) Dense() *DenseMatrix {
+	ret := NewDenseMatrix(m.N)
+	for i := 0; i < m.N; i++ {
+		cols, values := m.Row(i)
+		for k, j := range cols {
+			ret.Set(i, j, values[k])
+		}
+	}
+	return ret
+}
+
+// DenseAdjacency returns the adjacency matrix of the indexed graph,
+// and the nodes in the order of the rows. Element (i,j) is the total
+// weight of the edges from node i to node j.
+func (index *Index) DenseAdjacency(opts MatrixOptions) (*DenseMatrix, []Node) {
+	nodes, ids := opts.order(index)
+	m := NewDenseMatrix(len(nodes))
+	for i, node := range nodes {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			if j, ok := ids[edge.GetTo()]; ok {
+				m.Set(i, j, m.At(i, j)+opts.weight(edge))
+			}
+		}
+	}
+	return m, nodes
+}
+
+// SparseAdjacency returns the adjacency matrix of the indexed graph
+// in compressed sparse row format, and the nodes in the order of the
+// rows. Element (i,j) is the total weight of the edges from node i to
+// node j.
+func (index *Index) SparseAdjacency(opts MatrixOptions) (*SparseMatrix, []Node) {
+	nodes, ids := opts.order(index)
+	m := &SparseMatrix{N: len(nodes), RowPtr: make([]int, 1, len(nodes)+1)}
+	row := make(map[int]float64)
+	cols := make([]int, 0)
+	for _, node := range nodes {
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			if j, ok := ids[edge.GetTo()]; ok {
+				if _, seen := row[j]; !seen {
+					cols = append(cols, j)
+				}
+				row[j] += opts.weight(edge)
+			}
+		}
+		sort.Ints(cols)
+		for _, j := range cols {
+			m.Cols = append(m.Cols, j)
+			m.Values = append(m.Values, row[j])
+			delete(row, j)
+		}
+		cols = cols[:0]
+		m.RowPtr = append(m.RowPtr, len(m.Cols))
+	}
+	return m, nodes
+}
+
+// BoolMatrix is a square boolean matrix. Each row is a bitset
+type BoolMatrix []Bitset
+
+// NewBoolMatrix returns an n x n boolean matrix with all elements false
+func NewBoolMatrix(n int) BoolMatrix {
+	ret := make(BoolMatrix, n)
+	for i := range ret {
+		ret[i] = NewBitset(n)
+	}
+	return ret
+}
+
+// Bool returns a boolean matrix that is true where m is nonzero
+func (m *SparseMatrix) Bool() BoolMatrix {
+	ret := NewBoolMatrix(m.N)
+	for i := 0; i < m.N; i++ {
+		cols, values := m.Row(i)
+		for k, j := range cols {
+			if values[k] != 0 {
+				ret[i].Set(j)
+			}
+		}
+	}
+	return ret
+}
+
+// Test returns element (i,j)
+func (m BoolMatrix) Test(i, j int) bool { return m[i].Test(j) }
+
+// Mul returns the boolean product of m and other. Element (i,j) of the
+// product is true if there is a k such that m(i,k) and other(k,j).
+func (m BoolMatrix) Mul(other BoolMatrix) BoolMatrix {
+	ret := NewBoolMatrix(len(m))
+	for i, row := range m {
+		for k := range other {
+			if row.Test(k) {
+				for w, bits := range other[k] {
+					ret[i][w] |= bits
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Power returns m raised to the power k by repeated squaring. For an
+// adjacency matrix, element (i,j) of the result is true if there is a
+// walk of exactly k edges from node i to node j. The zeroth power is
+// the identity matrix. The result never shares storage with m. k must
+// not be negative.
+func (m BoolMatrix) Power(k int) BoolMatrix {
+	if k < 0 {
+		panic("Negative power")
+	}
+	if k == 0 {
+		ret := NewBoolMatrix(len(m))
+		for i := range ret {
+			ret[i].Set(i)
+		}
+		return ret
+	}
+	var ret BoolMatrix
+	base := m
+	for ; k > 0; k >>= 1 {
+		if k&1 == 1 {
+			if ret == nil {
+				ret = make(BoolMatrix, len(base))
+				for i := range base {
+					ret[i] = append(Bitset{}, base[i]...)
+				}
+			} else {
+				ret = ret.Mul(base)
+			}
+		}
+		if k > 1 {
+			base = base.Mul(base)
+		}
+	}
+	return ret
+}
+
+// Closure returns the transitive closure of m. For an adjacency
+// matrix, element (i,j) of the result is true if node j is reachable
+// from node i by a path of one or more edges. The closure is computed
+// by squaring R+R*R until it does not change, which takes O(log n)
+// boolean matrix products.
+func (m BoolMatrix) Closure() BoolMatrix {
+	ret := make(BoolMatrix, len(m))
+	for i := range m {
+		ret[i] = append(Bitset{}, m[i]...)
+	}
+	for {
+		sq := ret.Mul(ret)
+		changed := false
+		for i := range ret {
+			for w, bits := range sq[i] {
+				if ret[i][w]|bits != ret[i][w] {
+					ret[i][w] |= bits
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			return ret
+		}
+	}
+}
+
+// SpectralRadius returns the largest eigenvalue of a matrix with
+// nonnegative elements, such as an adjacency matrix with nonnegative
+// weights, using power iteration. The iteration runs on m+I, which
+// has the same dominant eigenvector and converges for periodic
+// graphs. It stops when the estimate changes less than tolerance, or
+// after the given number of iterations.
+func (m *SparseMatrix) SpectralRadius(iterations int, tolerance float64) float64 {
+	if m.N == 0 {
+		return 0
+	}
+	x := make([]float64, m.N)
+	for i := range x {
+		x[i] = 1 / math.Sqrt(float64(m.N))
+	}
+	lambda := 0.0
+	for it := 0; it < iterations; it++ {
+		y := m.MulVec(x)
+		norm := 0.0
+		for i := range y {
+			y[i] += x[i]
+			norm += y[i] * y[i]
+		}
+		norm = math.Sqrt(norm)
+		if norm == 0 {
+			return 0
+		}
+		for i := range y {
+			y[i] /= norm
+		}
+		x = y
+		// x is normalized, so |(m+I)x| estimates the eigenvalue of m+I
+		done := math.Abs(norm-1-lambda) < tolerance
+		lambda = norm - 1
+		if done {
+			break
+		}
+	}
+	return lambda
+}
@@ -0,0 +1,277 @@
+package digraph
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var safeIDRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z_0-9]*$`)
+
+// MermaidID returns s as a Mermaid node ID. IDs that are not
+// alphanumeric are prefixed with id_, and characters that are not
+// letters or digits are replaced by their code points, so distinct
+// strings map to distinct IDs.
+func MermaidID(s string) string {
+	if s == "end" {
+		return "id_end"
+	}
+	return safeID(s)
+}
+
+// safeID returns s if it is a valid identifier that does not start
+// with id_, or an escaped identifier otherwise
+func safeID(s string) string {
+	if safeIDRegex.MatchString(s) && !strings.HasPrefix(s, "id_") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString("id_")
+	for _, r := range s {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "_%x_", r)
+		}
+	}
+	return b.String()
+}
+
+// MermaidQuote returns s as a quoted Mermaid label. Quotes and
+// characters with special meaning are written as entity codes, and
+// newlines as line breaks.
+func MermaidQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString("#quot;")
+		case '#':
+			b.WriteString("#35;")
+		case '<':
+			b.WriteString("#lt;")
+		case '>':
+			b.WriteString("#gt;")
+		case '\n':
+			b.WriteString("<br/>")
+		case '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// MermaidRenderer renders a graph as a Mermaid flowchart
+type MermaidRenderer struct {
+	// Direction is the flowchart direction, one of LR, RL, TB, BT. If
+	// empty, LR is used
+	Direction string
+	// NodeRenderer renders a node. If the node is to be excluded,
+	// returns false. If nil, DefaultMermaidNodeRender is used
+	NodeRenderer func(ID string, node Node, w io.Writer) (bool, error)
+	// EdgeRenderer renders an edge. The from and to nodes are rendered
+	// if this is called. If the edge is to be excluded, returns
+	// false. If nil, DefaultMermaidEdgeRender is used
+	EdgeRenderer func(fromID string, toID string, edge Edge, w io.Writer) (bool, error)
+	// NodeLess, if set, orders the nodes in the output
+	NodeLess func(a, b Node) bool
+	// EdgeLess, if set, orders the outgoing edges of a node in the
+	// output
+	EdgeLess func(a, b Edge) bool
+}
+
+// DefaultMermaidNodeRender renders the node with the given ID. If the
+// node has a label, it uses that label, otherwise node is not
+// labeled.
+func DefaultMermaidNodeRender(ID string, node Node, w io.Writer) error {
+	var err error
+	if lbl := node.GetLabel(); lbl != nil {
+		_, err = fmt.Fprintf(w, "  %s[%s]\n", MermaidID(ID), MermaidQuote(fmt.Sprint(lbl)))
+	} else {
+		_, err = fmt.Fprintf(w, "  %s\n", MermaidID(ID))
+	}
+	return err
+}
+
+// DefaultMermaidEdgeRender renders the edge with a label if there is
+// one, or without a label if there is not a label.
+func DefaultMermaidEdgeRender(fromID, toID string, edge Edge, w io.Writer) error {
+	var err error
+	if lbl := edge.GetLabel(); lbl != nil {
+		_, err = fmt.Fprintf(w, "  %s -->|%s| %s\n", MermaidID(fromID), MermaidQuote(fmt.Sprint(lbl)), MermaidID(toID))
+	} else {
+		_, err = fmt.Fprintf(w, "  %s --> %s\n", MermaidID(fromID), MermaidID(toID))
+	}
+	return err
+}
+
+// RenderNode renders a node
+func (m MermaidRenderer) RenderNode(ID string, node Node, w io.Writer) (bool, error) {
+	if m.NodeRenderer != nil {
+		return m.NodeRenderer(ID, node, w)
+	}
+	return true, DefaultMermaidNodeRender(ID, node, w)
+}
+
+// RenderEdge renders an edge
+func (m MermaidRenderer) RenderEdge(fromID, toID string, edge Edge, w io.Writer) (bool, error) {
+	if m.EdgeRenderer != nil {
+		return m.EdgeRenderer(fromID, toID, edge, w)
+	}
+	return true, DefaultMermaidEdgeRender(fromID, toID, edge, w)
+}
+
+// Render writes the graph as a Mermaid flowchart
+func (m MermaidRenderer) Render(g *Graph, out io.Writer) error {
+	dir := m.Direction
+	if dir == "" {
+		dir = "LR"
+	}
+	if _, err := fmt.Fprintf(out, "flowchart %s\n", dir); err != nil {
+		return err
+	}
+	return renderNodesAndEdges(g, m.NodeLess, m.EdgeLess, m.RenderNode, m.RenderEdge, out)
+}
+
+// PlantUMLID returns s as a PlantUML element alias. Characters that
+// are not letters or digits are escaped as in MermaidID.
+func PlantUMLID(s string) string {
+	return safeID(s)
+}
+
+// PlantUMLQuote returns s as a quoted PlantUML string. Quotes are
+// written as unicode escapes, and newlines as \n.
+func PlantUMLQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString("<U+0022>")
+		case '\\':
+			b.WriteString("<U+005C>")
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// PlantUMLRenderer renders a graph as a PlantUML diagram. Nodes are
+// written as elements of the given kind.
+type PlantUMLRenderer struct {
+	// Element is the PlantUML element used for nodes, such as
+	// rectangle, component, or node. If empty, rectangle is used
+	Element string
+	// NodeRenderer renders a node. If the node is to be excluded,
+	// returns false. If nil, nodes are rendered as Element
+	NodeRenderer func(ID string, node Node, w io.Writer) (bool, error)
+	// EdgeRenderer renders an edge. The from and to nodes are rendered
+	// if this is called. If the edge is to be excluded, returns
+	// false. If nil, DefaultPlantUMLEdgeRender is used
+	EdgeRenderer func(fromID string, toID string, edge Edge, w io.Writer) (bool, error)
+	// NodeLess, if set, orders the nodes in the output
+	NodeLess func(a, b Node) bool
+	// EdgeLess, if set, orders the outgoing edges of a node in the
+	// output
+	EdgeLess func(a, b Edge) bool
+}
+
+// DefaultPlantUMLNodeRender renders the node as an element of the
+// given kind. If the node has a label, it uses that label, otherwise
+// the node is labeled by its ID.
+func DefaultPlantUMLNodeRender(element, ID string, node Node, w io.Writer) error {
+	label := ID
+	if lbl := node.GetLabel(); lbl != nil {
+		label = fmt.Sprint(lbl)
+	}
+	_, err := fmt.Fprintf(w, "%s %s as %s\n", element, PlantUMLQuote(label), PlantUMLID(ID))
+	return err
+}
+
+// DefaultPlantUMLEdgeRender renders the edge with a label if there is
+// one, or without a label if there is not a label.
+func DefaultPlantUMLEdgeRender(fromID, toID string, edge Edge, w io.Writer) error {
+	var err error
+	if lbl := edge.GetLabel(); lbl != nil {
+		_, err = fmt.Fprintf(w, "%s --> %s : %s\n", PlantUMLID(fromID), PlantUMLID(toID), PlantUMLQuote(fmt.Sprint(lbl)))
+	} else {
+		_, err = fmt.Fprintf(w, "%s --> %s\n", PlantUMLID(fromID), PlantUMLID(toID))
+	}
+	return err
+}
+
+// RenderNode renders a node
+func (p PlantUMLRenderer) RenderNode(ID string, node Node, w io.Writer) (bool, error) {
+	if p.NodeRenderer != nil {
+		return p.NodeRenderer(ID, node, w)
+	}
+	element := p.Element
+	if element == "" {
+		element = "rectangle"
+	}
+	return true, DefaultPlantUMLNodeRender(element, ID, node, w)
+}
+
+// RenderEdge renders an edge
+func (p PlantUMLRenderer) RenderEdge(fromID, toID string, edge Edge, w io.Writer) (bool, error) {
+	if p.EdgeRenderer != nil {
+		return p.EdgeRenderer(fromID, toID, edge, w)
+	}
+	return true, DefaultPlantUMLEdgeRender(fromID, toID, edge, w)
+}
+
+// Render writes the graph as a PlantUML diagram
+func (p PlantUMLRenderer) Render(g *Graph, out io.Writer) error {
+	if _, err := fmt.Fprintf(out, "@startuml\n"); err != nil {
+		return err
+	}
+	if err := renderNodesAndEdges(g, p.NodeLess, p.EdgeLess, p.RenderNode, p.RenderEdge, out); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "@enduml\n")
+	return err
+}
+
+// renderNodesAndEdges renders all nodes of the graph, followed by the
+// edges between rendered nodes
+func renderNodesAndEdges(g *Graph,
+	nodeLess func(a, b Node) bool,
+	edgeLess func(a, b Edge) bool,
+	renderNode func(string, Node, io.Writer) (bool, error),
+	renderEdge func(string, string, Edge, io.Writer) (bool, error),
+	out io.Writer) error {
+	allNodes, ids := renderNodeIDs(g, nodeLess)
+	nodeMap := map[Node]string{}
+	for _, node := range allNodes {
+		rendered, err := renderNode(ids[node], node, out)
+		if err != nil {
+			return err
+		}
+		if rendered {
+			nodeMap[node] = ids[node]
+		}
+	}
+	for _, node := range allNodes {
+		edgeItr := renderOut(node, edgeLess)
+		for edgeItr.HasNext() {
+			edge := edgeItr.Next()
+			fromID, ok1 := nodeMap[edge.GetFrom()]
+			toID, ok2 := nodeMap[edge.GetTo()]
+			if ok1 && ok2 {
+				if _, err := renderEdge(fromID, toID, edge, out); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
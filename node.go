@@ -5,12 +5,17 @@ type Node interface {
 	GetLabel() interface{}
 	SetLabel(interface{})
 
+	// Returns the stable ID of the node assigned by a graph, or 0
+	ID() uint64
+
 	// Returns if the node has any outgoing edges
 	HasOut() bool
 	// Returns all outgoing edges of the node
 	Out() Edges
 	// Returns the outgoing edges with the given label
 	OutWith(interface{}) Edges
+	// Returns the outgoing edges to the given node
+	OutTo(Node) Edges
 
 	// Returns all directly accessible nodes
 	Next() []Node
@@ -20,12 +25,31 @@ type Node interface {
 
 	removeOutgoingEdge(Edge)
 	addOutgoingEdge(Edge)
+	relabelOutgoingEdge(Edge, interface{})
+	getNodeHeader() *NodeHeader
 }
 
 // NodeHeader must be embedded into every node
 type NodeHeader struct {
 	label interface{}
 	out   edgeSet
+	// view is the state of the node captured for snapshots. It is
+	// cleared when the node is modified
+	view *nodeView
+	// graph is the graph this node belongs to. Modifications are
+	// reported to this graph
+	graph *Graph
+	// self is the node embedding this header. Set when the node
+	// joins a graph
+	self Node
+	// storage selects the edgeSet implementation
+	storage EdgeStorage
+	// id is the stable ID of the node, assigned by the graph
+	id uint64
+}
+
+func (hdr *NodeHeader) getNodeHeader() *NodeHeader {
+	return hdr
 }
 
 // GetLabel returns the node label
@@ -35,18 +59,26 @@ func (hdr *NodeHeader) GetLabel() interface{} {
 
 // SetLabel sets the node label
 func (hdr *NodeHeader) SetLabel(label interface{}) {
+	hdr.modified()
+	old := hdr.label
 	hdr.label = label
+	if hdr.graph != nil {
+		hdr.graph.notify(Event{Type: NodeLabelChanged, Node: hdr.self, OldLabel: old, NewLabel: label})
+	}
 }
 
 // addOutgoingEdge adds a new outgoing edge to this node. The edge must be disconnected.
 func (hdr *NodeHeader) addOutgoingEdge(edge Edge) {
+	hdr.modified()
 	if hdr.out == nil {
-		hdr.out = newSliceEdgeSet()
+		hdr.out = newEdgeSet(hdr.storage, nil)
 	}
 	hdr.out.addEdge(edge)
-	if sl, ok := hdr.out.(*sliceEdgeSet); ok {
-		if sl.length() > 10 {
-			hdr.out = sl.toMap()
+	if hdr.storage == AdaptiveStorage {
+		if sl, ok := hdr.out.(*sliceEdgeSet); ok {
+			if sl.length() > adaptiveThreshold {
+				hdr.out = newEdgeSet(TargetMapStorage, sl.edges())
+			}
 		}
 	}
 }
@@ -79,7 +111,34 @@ func (hdr *NodeHeader) removeOutgoingEdge(edge Edge) {
 	if hdr.out == nil {
 		return
 	}
+	hdr.modified()
 	hdr.out.removeEdge(edge)
+	if hdr.storage == AdaptiveStorage {
+		if _, ok := hdr.out.(*sliceEdgeSet); !ok && hdr.out.length() < adaptiveThreshold/2 {
+			hdr.out = newEdgeSet(SliceStorage, hdr.out.edges())
+		}
+	}
+}
+
+// restoreOutgoingEdges rebuilds the outgoing edge set of the node with
+// the edges in the given order. The edges must be the current
+// outgoing edges of the node
+func (hdr *NodeHeader) restoreOutgoingEdges(edges []Edge) {
+	if hdr.out == nil {
+		return
+	}
+	hdr.modified()
+	hdr.out = newEdgeSet(hdr.storage, edges)
+}
+
+// relabelOutgoingEdge updates the edge set after the label key of the
+// edge is changed from oldKey. modified must be called before the
+// label is changed
+func (hdr *NodeHeader) relabelOutgoingEdge(edge Edge, oldKey interface{}) {
+	if hdr.out == nil {
+		return
+	}
+	hdr.out.relabelEdge(edge, oldKey)
 }
 
 // Out returns all outgoing edges of the node
@@ -98,6 +157,14 @@ func (hdr *NodeHeader) OutWith(label interface{}) Edges {
 	return hdr.out.getEdgesWith(label)
 }
 
+// OutTo returns all edges to the given node
+func (hdr *NodeHeader) OutTo(to Node) Edges {
+	if hdr.out == nil {
+		return Edges{&edgeSliceIterator{}}
+	}
+	return Edges{&edgeSliceIterator{Edges: hdr.out.edgesTo(to)}}
+}
+
 // BasicNode contains an application defined payload
 type BasicNode struct {
 	NodeHeader
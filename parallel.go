@@ -0,0 +1,199 @@
+package digraph
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// numWorkers returns the number of goroutines to use. If workers is
+// not positive, returns GOMAXPROCS
+func numWorkers(workers int) int {
+	if workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return workers
+}
+
+// parallelFor splits [0,n) into at most workers contiguous chunks and
+// calls f for each chunk in a separate goroutine. The chunk with the
+// smaller indexes is passed to the worker with the smaller worker
+// index. Returns when all calls return.
+func parallelFor(n, workers int, f func(worker, lo, hi int)) {
+	if n == 0 {
+		return
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers == 1 {
+		f(0, 0, n)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		lo := w * chunk
+		if lo >= n {
+			break
+		}
+		hi := lo + chunk
+		if hi > n {
+			hi = n
+		}
+		wg.Add(1)
+		go func(w, lo, hi int) {
+			defer wg.Done()
+			f(w, lo, hi)
+		}(w, lo, hi)
+	}
+	wg.Wait()
+}
+
+// bfsLevels performs a level-synchronous breadth-first search starting
+// at roots. For every level, levelFunc is called with the unique nodes
+// of that level that were not seen in the previous levels. The next
+// level is computed in parallel. Stops if levelFunc returns false.
+func bfsLevels(roots []Node, workers int, levelFunc func(level int, frontier []Node) bool) {
+	workers = numWorkers(workers)
+	seen := make(map[Node]struct{})
+	frontier := make([]Node, 0, len(roots))
+	for _, root := range roots {
+		if _, ok := seen[root]; !ok {
+			seen[root] = struct{}{}
+			frontier = append(frontier, root)
+		}
+	}
+	for level := 0; len(frontier) > 0; level++ {
+		if !levelFunc(level, frontier) {
+			return
+		}
+		next := make([][]Node, workers)
+		parallelFor(len(frontier), workers, func(w, lo, hi int) {
+			for _, node := range frontier[lo:hi] {
+				next[w] = append(next[w], node.Next()...)
+			}
+		})
+		frontier = make([]Node, 0, len(frontier))
+		for _, nodes := range next {
+			for _, node := range nodes {
+				if _, ok := seen[node]; !ok {
+					seen[node] = struct{}{}
+					frontier = append(frontier, node)
+				}
+			}
+		}
+	}
+}
+
+// ParallelBFS performs a level-synchronous breadth-first search
+// starting at roots using the given number of goroutines. If workers
+// is not positive, GOMAXPROCS goroutines are used. visit is called
+// once for every reachable node with the level of the node, the roots
+// being at level 0. Calls to visit for nodes of the same level are
+// made concurrently. If visit returns false, the search stops after
+// the current level.
+func ParallelBFS(roots []Node, workers int, visit func(node Node, level int) bool) {
+	workers = numWorkers(workers)
+	bfsLevels(roots, workers, func(level int, frontier []Node) bool {
+		var stop int32
+		parallelFor(len(frontier), workers, func(_, lo, hi int) {
+			for _, node := range frontier[lo:hi] {
+				if !visit(node, level) {
+					atomic.StoreInt32(&stop, 1)
+				}
+			}
+		})
+		return stop == 0
+	})
+}
+
+// PageRank computes the PageRank of all nodes of the index using the
+// given damping factor (usually 0.85) and number of iterations. See
+// FrozenGraph.PageRank.
+func PageRank(index *Index, damping float64, iterations int, workers int) map[Node]float64 {
+	f := Freeze(index)
+	ret := make(map[Node]float64, f.NumNodes())
+	for id, rank := range f.PageRank(damping, iterations, workers) {
+		ret[f.Node(id)] = rank
+	}
+	return ret
+}
+
+// BuildParallel constructs the index using the given number of
+// goroutines. If workers is not positive, GOMAXPROCS goroutines are
+// used. Nodes are discovered in breadth-first order, so the order of
+// NodesSlice is different from a lazily constructed index.
+func (index *Index) BuildParallel(workers int) {
+	workers = numWorkers(workers)
+	allNodes := make([]Node, 0)
+	bfsLevels(index.g.roots(), workers, func(_ int, frontier []Node) bool {
+		allNodes = append(allNodes, frontier...)
+		return true
+	})
+	ids := make(map[Node]int, len(allNodes))
+	for i, node := range allNodes {
+		ids[node] = i
+	}
+
+	// Each worker collects incoming edges of the nodes in its chunk,
+	// bucketed by the shard of the target node. Then each shard is
+	// merged by a separate worker.
+	buckets := make([][][]Edge, workers)
+	parallelFor(len(allNodes), workers, func(w, lo, hi int) {
+		shards := make([][]Edge, workers)
+		for _, node := range allNodes[lo:hi] {
+			for edges := node.Out(); edges.HasNext(); {
+				edge := edges.Next()
+				shard := ids[edge.GetTo()] % workers
+				shards[shard] = append(shards[shard], edge)
+			}
+		}
+		buckets[w] = shards
+	})
+	type shardIndex struct {
+		incoming        map[Node][]Edge
+		incomingByLabel map[Node]map[interface{}][]Edge
+	}
+	shards := make([]shardIndex, workers)
+	parallelFor(workers, workers, func(shard, _, _ int) {
+		ix := shardIndex{
+			incoming:        make(map[Node][]Edge),
+			incomingByLabel: make(map[Node]map[interface{}][]Edge),
+		}
+		for _, bucket := range buckets {
+			if bucket == nil {
+				continue
+			}
+			for _, edge := range bucket[shard] {
+				to := edge.GetTo()
+				ix.incoming[to] = append(ix.incoming[to], edge)
+				m := ix.incomingByLabel[to]
+				if m == nil {
+					m = make(map[interface{}][]Edge)
+					ix.incomingByLabel[to] = m
+				}
+				key := edgeKey(edge)
+				m[key] = append(m[key], edge)
+			}
+		}
+		shards[shard] = ix
+	})
+
+	index.allNodes = allNodes
+	index.allNodesByLabel = make(map[interface{}][]Node)
+	for _, node := range allNodes {
+		key := LabelKey(node.GetLabel())
+		index.allNodesByLabel[key] = append(index.allNodesByLabel[key], node)
+	}
+	index.incomingEdges = make(map[Node][]Edge, len(allNodes))
+	index.incomingEdgesByLabel = make(map[Node]map[interface{}][]Edge, len(allNodes))
+	for _, shard := range shards {
+		for node, edges := range shard.incoming {
+			index.incomingEdges[node] = edges
+		}
+		for node, m := range shard.incomingByLabel {
+			index.incomingEdgesByLabel[node] = m
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package digraph
+
+import (
+	"errors"
+)
+
+var (
+	// ErrCycle is returned when an edge is rejected because it would
+	// create a cycle in an acyclic graph
+	ErrCycle = errors.New("edge would create a cycle")
+	// ErrParallelEdge is returned when an edge is rejected because
+	// there is already an edge with the same label between the same
+	// nodes
+	ErrParallelEdge = errors.New("parallel edges are forbidden")
+	// ErrSchemaViolation is returned when an edge is rejected because
+	// the graph schema does not allow the edge label between the node
+	// labels
+	ErrSchemaViolation = errors.New("edge violates graph schema")
+)
+
+// Policy declares the constraints enforced by Graph.Connect. The zero
+// value of a Policy does not enforce any constraints.
+//
+// Policies are only enforced by Graph.Connect. Nodes connected
+// directly using Connect or TryConnect are not checked.
+type Policy struct {
+	// Acyclic rejects edges that would create a cycle. Graph.Connect
+	// keeps a topological order of the graph, and only searches the
+	// nodes between the endpoints of an edge that goes against the
+	// order
+	Acyclic bool
+	// NoSelfLoops rejects edges from a node to itself
+	NoSelfLoops bool
+	// NoParallelEdges rejects an edge if there is already an edge with
+	// the same label between the same nodes
+	NoParallelEdges bool
+	// Schema declares the edge labels that can connect node labels. If
+	// nil, all edges are allowed
+	Schema *Schema
+}
+
+// AnyLabel can be used in a schema rule to match any label
+var AnyLabel interface{} = anyLabel{}
+
+type anyLabel struct{}
+
+type schemaRule struct {
+	from, edge, to interface{}
+}
+
+// Schema declares which edge labels may connect which node labels
+type Schema struct {
+	rules map[schemaRule]struct{}
+}
+
+// NewSchema returns a new schema that does not allow any edges
+func NewSchema() *Schema {
+	return &Schema{rules: make(map[schemaRule]struct{})}
+}
+
+// Allow adds a rule that allows edges labeled edgeLabel from nodes
+// labeled fromLabel to nodes labeled toLabel. Any of the labels can
+// be AnyLabel. Returns the schema so calls can be chained.
+func (s *Schema) Allow(fromLabel, edgeLabel, toLabel interface{}) *Schema {
+	if s.rules == nil {
+		s.rules = make(map[schemaRule]struct{})
+	}
+	s.rules[schemaRule{from: LabelKey(fromLabel), edge: LabelKey(edgeLabel), to: LabelKey(toLabel)}] = struct{}{}
+	return s
+}
+
+// Allows returns true if an edge labeled edgeLabel is allowed from a
+// node labeled fromLabel to a node labeled toLabel
+func (s *Schema) Allows(fromLabel, edgeLabel, toLabel interface{}) bool {
+	for _, from := range [2]interface{}{LabelKey(fromLabel), AnyLabel} {
+		for _, edge := range [2]interface{}{LabelKey(edgeLabel), AnyLabel} {
+			for _, to := range [2]interface{}{LabelKey(toLabel), AnyLabel} {
+				if _, ok := s.rules[schemaRule{from: from, edge: edge, to: to}]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Check returns an error if connecting from and to nodes with the
+// given edge violates the policy. The acyclic check searches the nodes
+// accessible from the to node. Graph.Connect uses the topological
+// order of the graph instead, which is maintained incrementally.
+func (p Policy) Check(from, to Node, edge Edge) error {
+	return p.check(nil, from, to, edge)
+}
+
+// check returns an error if the edge violates the policy. If g is not
+// nil, cycles are detected using the topological order of g
+func (p Policy) check(g *Graph, from, to Node, edge Edge) error {
+	if p.NoSelfLoops && from == to {
+		return ErrSelfLoopForbidden
+	}
+	if p.Schema != nil && !p.Schema.Allows(from.GetLabel(), edge.GetLabel(), to.GetLabel()) {
+		return ErrSchemaViolation
+	}
+	if p.NoParallelEdges && HasEdge(from, to, edge.GetLabel()) {
+		return ErrParallelEdge
+	}
+	if p.Acyclic {
+		if g != nil {
+			if g.createsCycle(from, to) {
+				return ErrCycle
+			}
+		} else if Reachable(to, from) {
+			return ErrCycle
+		}
+	}
+	return nil
+}
+
+// Reachable returns true if target can be reached from source by
+// following outgoing edges. A node is always reachable from itself.
+func Reachable(source, target Node) bool {
+	if source == target {
+		return true
+	}
+	seen := map[Node]struct{}{source: {}}
+	stack := []Node{source}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range node.Next() {
+			if next == target {
+				return true
+			}
+			if _, ok := seen[next]; !ok {
+				seen[next] = struct{}{}
+				stack = append(stack, next)
+			}
+		}
+	}
+	return false
+}
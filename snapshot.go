@@ -0,0 +1,371 @@
+package digraph
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a read-only view of a graph at the time the snapshot is
+// taken. Taking a snapshot is O(1): nothing is copied until the graph
+// is modified. Before a node of the graph is modified for the first
+// time after a snapshot is taken, its label and outgoing edges are
+// saved for the snapshot. Unmodified nodes are read from the graph.
+//
+// The state of a node is captured with the labels and the endpoints
+// of its outgoing edges, so relabeling, disconnecting, or connecting
+// the edges again does not change the snapshot. Captured states are
+// immutable, and are shared by all snapshots taken while the node is
+// not modified.
+//
+// A snapshot can be read by multiple goroutines concurrently with
+// modifications to the graph. Taking a snapshot is a write operation.
+// A snapshot that is no longer needed should be released using
+// Release. Once all snapshots of a graph are released, the saved
+// states are dropped, and modifications no longer save states.
+// Nodes that belong to a different graph, or that do not belong to a
+// graph, are read as they are when the snapshot is read.
+type Snapshot struct {
+	snaps *snapshots
+	// released is set to 1 when the snapshot is released
+	released int32
+	// next is the snapshot taken after this one
+	next *Snapshot
+	// saved keeps the state of the nodes that were modified after
+	// this snapshot was taken, and before the next one
+	saved map[Node]*nodeView
+	// roots keeps the nodes known by the graph if they were modified
+	// after this snapshot was taken, and before the next one
+	roots      []Node
+	rootsSaved bool
+
+	nodesOnce sync.Once
+	nodes     []Node
+	nodeSet   map[Node]struct{}
+
+	endpointsOnce sync.Once
+	endpoints     map[Edge][2]Node
+
+	inOnce        sync.Once
+	incomingEdges map[Node][]Edge
+}
+
+// snapshots keeps the latest snapshot of a graph. mu protects the
+// saved states of all snapshots of the graph, and the views cached in
+// the nodes of the graph
+type snapshots struct {
+	g      *Graph
+	mu     sync.Mutex
+	latest *Snapshot
+	// live is the number of snapshots that are not released. It is
+	// updated atomically, because snapshots can be released by any
+	// goroutine
+	live int32
+}
+
+// nodeView is the state of a node captured for snapshots
+type nodeView struct {
+	label  interface{}
+	out    []Edge
+	to     []Node
+	labels []interface{}
+}
+
+// newNodeView captures the current state of a node
+func newNodeView(hdr *NodeHeader) *nodeView {
+	view := &nodeView{label: hdr.label}
+	if hdr.out != nil {
+		edges := hdr.out.edges()
+		view.out = make([]Edge, len(edges))
+		view.to = make([]Node, len(edges))
+		view.labels = make([]interface{}, len(edges))
+		for i, edge := range edges {
+			eh := edge.getEdgeHeader()
+			view.out[i] = edge
+			view.to[i] = eh.to
+			view.labels[i] = eh.label
+		}
+	}
+	return view
+}
+
+// view returns the view of a node of the graph. Must be called with
+// mu held
+func (s *snapshots) view(node Node) *nodeView {
+	hdr := node.getNodeHeader()
+	if hdr.graph != s.g {
+		return newNodeView(hdr)
+	}
+	if hdr.view == nil {
+		hdr.view = newNodeView(hdr)
+	}
+	return hdr.view
+}
+
+// modified is called before a node of the graph is modified. Saves the
+// state of the node for the latest snapshot if it is not saved yet
+func (s *snapshots) modified(node Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.latest.saved[node]; !ok {
+		s.latest.saved[node] = s.view(node)
+	}
+	node.getNodeHeader().view = nil
+}
+
+// rootsModified is called before the nodes known by the graph are
+// modified. Saves the nodes for the latest snapshot if they are not
+// saved yet
+func (s *snapshots) rootsModified() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.latest.rootsSaved {
+		s.latest.roots = s.g.roots()
+		s.latest.rootsSaved = true
+	}
+}
+
+// liveSnapshots returns the snapshots of the graph, or nil if all
+// snapshots are released. The saved states are dropped when the
+// last snapshot is found to be released. Must be called by the
+// goroutine modifying the graph
+func (g *Graph) liveSnapshots() *snapshots {
+	if g.snaps != nil && atomic.LoadInt32(&g.snaps.live) == 0 {
+		g.snaps = nil
+	}
+	return g.snaps
+}
+
+// modified is called before the node is modified
+func (hdr *NodeHeader) modified() {
+	if hdr.graph != nil {
+		if s := hdr.graph.liveSnapshots(); s != nil {
+			s.modified(hdr.self)
+			return
+		}
+	}
+	hdr.view = nil
+}
+
+// rootsModified is called before the nodes known by the graph are
+// modified
+func (g *Graph) rootsModified() {
+	if s := g.liveSnapshots(); s != nil {
+		s.rootsModified()
+	}
+}
+
+// Snapshot returns a read-only snapshot of all accessible nodes and
+// edges of the graph
+func (g *Graph) Snapshot() *Snapshot {
+	s := g.liveSnapshots()
+	if s == nil {
+		s = &snapshots{g: g}
+		g.snaps = s
+	}
+	atomic.AddInt32(&s.live, 1)
+	snap := &Snapshot{snaps: s, saved: make(map[Node]*nodeView)}
+	s.mu.Lock()
+	if s.latest != nil {
+		s.latest.next = snap
+	}
+	s.latest = snap
+	s.mu.Unlock()
+	return snap
+}
+
+// Release releases the snapshot. The snapshot must not be used after
+// it is released. Release can be called by any goroutine, and more
+// than once.
+func (snap *Snapshot) Release() {
+	if atomic.CompareAndSwapInt32(&snap.released, 0, 1) {
+		atomic.AddInt32(&snap.snaps.live, -1)
+	}
+}
+
+// view returns the state of the node when the snapshot was taken. The
+// state is saved in this snapshot, or in one of the later snapshots if
+// the node was modified after them. Otherwise the node is not
+// modified since the snapshot was taken.
+func (snap *Snapshot) view(node Node) *nodeView {
+	snap.snaps.mu.Lock()
+	defer snap.snaps.mu.Unlock()
+	for s := snap; s != nil; s = s.next {
+		if view, ok := s.saved[node]; ok {
+			return view
+		}
+	}
+	return snap.snaps.view(node)
+}
+
+// getRoots returns the nodes known by the graph when the snapshot was
+// taken
+func (snap *Snapshot) getRoots() []Node {
+	snap.snaps.mu.Lock()
+	defer snap.snaps.mu.Unlock()
+	for s := snap; s != nil; s = s.next {
+		if s.rootsSaved {
+			return s.roots
+		}
+	}
+	return snap.snaps.g.roots()
+}
+
+// NodesSlice returns all nodes of the snapshot. The nodes are found
+// by a traversal of the snapshot the first time they are requested.
+// The returned slice must not be modified.
+func (snap *Snapshot) NodesSlice() []Node {
+	snap.nodesOnce.Do(func() {
+		snap.nodes = make([]Node, 0)
+		snap.nodeSet = make(map[Node]struct{})
+		for _, root := range snap.getRoots() {
+			if _, ok := snap.nodeSet[root]; ok {
+				continue
+			}
+			snap.nodeSet[root] = struct{}{}
+			queue := []Node{root}
+			for len(queue) > 0 {
+				node := queue[0]
+				queue = queue[1:]
+				snap.nodes = append(snap.nodes, node)
+				for _, to := range snap.view(node).to {
+					if _, ok := snap.nodeSet[to]; !ok {
+						snap.nodeSet[to] = struct{}{}
+						queue = append(queue, to)
+					}
+				}
+			}
+		}
+	})
+	return snap.nodes
+}
+
+// Nodes returns an iterator over all nodes of the snapshot
+func (snap *Snapshot) Nodes() Nodes {
+	return NewNodeSliceIterator(snap.NodesSlice()...)
+}
+
+// Contains returns true if the node is in the snapshot
+func (snap *Snapshot) Contains(node Node) bool {
+	snap.NodesSlice()
+	_, ok := snap.nodeSet[node]
+	return ok
+}
+
+// Label returns the label of the node at the time the snapshot was
+// taken
+func (snap *Snapshot) Label(node Node) interface{} {
+	return snap.view(node).label
+}
+
+// NodesByLabelSlice returns the nodes that had the given label when
+// the snapshot was taken
+func (snap *Snapshot) NodesByLabelSlice(label interface{}) []Node {
+	key := LabelKey(label)
+	ret := make([]Node, 0)
+	for _, node := range snap.NodesSlice() {
+		if LabelKey(snap.Label(node)) == key {
+			ret = append(ret, node)
+		}
+	}
+	return ret
+}
+
+// OutSlice returns the outgoing edges of a node. The returned slice
+// must not be modified.
+func (snap *Snapshot) OutSlice(node Node) []Edge {
+	return snap.view(node).out
+}
+
+// Out returns the outgoing edges of a node
+func (snap *Snapshot) Out(node Node) Edges {
+	return NewEdges(snap.OutSlice(node)...)
+}
+
+// OutWith returns the outgoing edges of a node with the given label
+func (snap *Snapshot) OutWith(node Node, label interface{}) Edges {
+	key := LabelKey(label)
+	view := snap.view(node)
+	ret := make([]Edge, 0)
+	for i, edge := range view.out {
+		if LabelKey(view.labels[i]) == key {
+			ret = append(ret, edge)
+		}
+	}
+	return NewEdges(ret...)
+}
+
+// EdgeLabel returns the label of an edge at the time the snapshot was
+// taken
+func (snap *Snapshot) EdgeLabel(edge Edge) interface{} {
+	from := snap.Source(edge)
+	if from == nil {
+		return nil
+	}
+	view := snap.view(from)
+	for i, e := range view.out {
+		if e == edge {
+			return view.labels[i]
+		}
+	}
+	return nil
+}
+
+// getEndpoints returns the source and target nodes of an edge of the
+// snapshot
+func (snap *Snapshot) getEndpoints(edge Edge) [2]Node {
+	snap.endpointsOnce.Do(func() {
+		snap.endpoints = make(map[Edge][2]Node)
+		for _, node := range snap.NodesSlice() {
+			view := snap.view(node)
+			for i, e := range view.out {
+				snap.endpoints[e] = [2]Node{node, view.to[i]}
+			}
+		}
+	})
+	return snap.endpoints[edge]
+}
+
+// Source returns the source node of an edge of the snapshot
+func (snap *Snapshot) Source(edge Edge) Node {
+	return snap.getEndpoints(edge)[0]
+}
+
+// Target returns the target node of an edge of the snapshot
+func (snap *Snapshot) Target(edge Edge) Node {
+	return snap.getEndpoints(edge)[1]
+}
+
+// Next returns the unique nodes directly accessible from node
+func (snap *Snapshot) Next(node Node) []Node {
+	view := snap.view(node)
+	ret := make([]Node, 0, len(view.to))
+	seen := make(map[Node]struct{}, len(view.to))
+	for _, to := range view.to {
+		if _, ok := seen[to]; !ok {
+			seen[to] = struct{}{}
+			ret = append(ret, to)
+		}
+	}
+	return ret
+}
+
+// InSlice returns the incoming edges of a node. The returned slice
+// must not be modified.
+func (snap *Snapshot) InSlice(node Node) []Edge {
+	snap.inOnce.Do(func() {
+		incoming := make(map[Node][]Edge)
+		for _, n := range snap.NodesSlice() {
+			view := snap.view(n)
+			for i, edge := range view.out {
+				incoming[view.to[i]] = append(incoming[view.to[i]], edge)
+			}
+		}
+		snap.incomingEdges = incoming
+	})
+	return snap.incomingEdges[node]
+}
+
+// In returns the incoming edges of a node
+func (snap *Snapshot) In(node Node) Edges {
+	return NewEdges(snap.InSlice(node)...)
+}
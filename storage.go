@@ -0,0 +1,178 @@
+package digraph
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// EdgeStorage selects how the outgoing edges of a node are stored
+type EdgeStorage int
+
+const (
+	// AdaptiveStorage stores the edges in a slice, and switches to
+	// TargetMapStorage when the node has more than 10 edges. It
+	// switches back to a slice when the number of edges drops below
+	// 5. This is the default.
+	AdaptiveStorage EdgeStorage = iota
+	// SliceStorage stores the edges in a slice. It uses the least
+	// memory, but lookups by label and edge removal are O(n)
+	SliceStorage
+	// LabelMapStorage stores the edges in insertion order, and indexes
+	// them by label. Lookups by label and edge removal are O(1)
+	LabelMapStorage
+	// SortedStorage stores the edges in a slice sorted by label. Edges
+	// are iterated in label order. Lookups by label are O(log n), and
+	// insertion and removal are O(n) with little memory overhead
+	SortedStorage
+	// TargetMapStorage stores the edges as LabelMapStorage, and also
+	// indexes them by the target node, so looking up edges to a node
+	// is O(1)
+	TargetMapStorage
+)
+
+// adaptiveThreshold is the number of edges above which
+// AdaptiveStorage switches from a slice to a map
+const adaptiveThreshold = 10
+
+func (s EdgeStorage) String() string {
+	switch s {
+	case AdaptiveStorage:
+		return "AdaptiveStorage"
+	case SliceStorage:
+		return "SliceStorage"
+	case LabelMapStorage:
+		return "LabelMapStorage"
+	case SortedStorage:
+		return "SortedStorage"
+	case TargetMapStorage:
+		return "TargetMapStorage"
+	}
+	return "Unknown"
+}
+
+// SetEdgeStorage sets the default edge storage of the graph. Nodes
+// using AdaptiveStorage switch to this storage when they are added to
+// the graph, or connected using Graph.Connect.
+func (g *Graph) SetEdgeStorage(storage EdgeStorage) {
+	g.edgeStorage = storage
+}
+
+// GetEdgeStorage returns the default edge storage of the graph
+func (g *Graph) GetEdgeStorage() EdgeStorage {
+	return g.edgeStorage
+}
+
+// SetEdgeStorage changes how the outgoing edges of the node are
+// stored. Existing edges are moved to the new storage.
+func (hdr *NodeHeader) SetEdgeStorage(storage EdgeStorage) {
+	hdr.storage = storage
+	if hdr.out != nil {
+		hdr.modified()
+		hdr.out = newEdgeSet(storage, hdr.out.edges())
+	}
+}
+
+// GetEdgeStorage returns how the outgoing edges of the node are stored
+func (hdr *NodeHeader) GetEdgeStorage() EdgeStorage {
+	return hdr.storage
+}
+
+// sortKey is a label key prepared for ordering. Keys are ordered by
+// type name first. Keys of the same basic type are compared by value,
+// keys of other types are compared by their formatted values. The
+// type name and the formatted value are computed once.
+type sortKey struct {
+	key  interface{}
+	typ  string
+	kind reflect.Kind
+	i    int64
+	u    uint64
+	f    float64
+	s    string
+}
+
+func newSortKey(key interface{}) *sortKey {
+	k := &sortKey{key: key}
+	if key == nil {
+		return k
+	}
+	v := reflect.ValueOf(key)
+	k.typ = v.Type().String()
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		k.kind, k.i = reflect.Int64, v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		k.kind, k.u = reflect.Uint64, v.Uint()
+	case reflect.Float32, reflect.Float64:
+		k.kind, k.f = reflect.Float64, v.Float()
+	case reflect.String:
+		k.kind, k.s = reflect.String, v.String()
+	default:
+		k.s = fmt.Sprint(key)
+	}
+	return k
+}
+
+// compareSortKeys orders sort keys. nil is the smallest key, and NaN
+// is smaller than all other numbers of the same type.
+func compareSortKeys(k1, k2 *sortKey) int {
+	switch {
+	case k1.key == nil && k2.key == nil:
+		return 0
+	case k1.key == nil:
+		return -1
+	case k2.key == nil:
+		return 1
+	}
+	if c := strings.Compare(k1.typ, k2.typ); c != 0 {
+		return c
+	}
+	switch k1.kind {
+	case reflect.Int64:
+		return compareInt64(k1.i, k2.i)
+	case reflect.Uint64:
+		return compareUint64(k1.u, k2.u)
+	case reflect.Float64:
+		return compareFloat64(k1.f, k2.f)
+	}
+	return strings.Compare(k1.s, k2.s)
+}
+
+func compareInt64(i1, i2 int64) int {
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
+
+func compareUint64(i1, i2 uint64) int {
+	switch {
+	case i1 < i2:
+		return -1
+	case i1 > i2:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(f1, f2 float64) int {
+	nan1, nan2 := math.IsNaN(f1), math.IsNaN(f2)
+	switch {
+	case nan1 && nan2:
+		return 0
+	case nan1:
+		return -1
+	case nan2:
+		return 1
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	}
+	return 0
+}
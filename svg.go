@@ -0,0 +1,124 @@
+package digraph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SVGRenderer renders a graph as SVG using the layered layout
+type SVGRenderer struct {
+	// Layout options. If NodeSize is not set, nodes are sized to fit
+	// their labels
+	Layout LayoutOptions
+	// NodeLabel returns the text of a node. If nil, the node label is
+	// printed using fmt.Sprint, or nothing if the node has no label
+	NodeLabel func(Node) string
+	// EdgeLabel returns the text of an edge. If nil, the edge label is
+	// printed using fmt.Sprint, or nothing if the edge has no label
+	EdgeLabel func(Edge) string
+	// FontSize is the font size of labels. Default is 14
+	FontSize float64
+}
+
+func (s SVGRenderer) nodeLabel(node Node) string {
+	if s.NodeLabel != nil {
+		return s.NodeLabel(node)
+	}
+	if lbl := node.GetLabel(); lbl != nil {
+		return fmt.Sprint(lbl)
+	}
+	return ""
+}
+
+func (s SVGRenderer) edgeLabel(edge Edge) string {
+	if s.EdgeLabel != nil {
+		return s.EdgeLabel(edge)
+	}
+	if lbl := edge.GetLabel(); lbl != nil {
+		return fmt.Sprint(lbl)
+	}
+	return ""
+}
+
+// Render computes the layout of the graph and writes it as an SVG
+// document
+func (s SVGRenderer) Render(g *Graph, out io.Writer) error {
+	fontSize := s.FontSize
+	if fontSize == 0 {
+		fontSize = 14
+	}
+	opts := s.Layout
+	if opts.NodeSize == nil {
+		opts.NodeSize = func(node Node) (float64, float64) {
+			w := float64(len([]rune(s.nodeLabel(node))))*fontSize*0.6 + fontSize*1.5
+			if w < fontSize*3 {
+				w = fontSize * 3
+			}
+			return w, fontSize * 2
+		}
+	}
+	return s.RenderLayout(LayoutGraph(g, opts), out)
+}
+
+// RenderLayout writes a computed layout as an SVG document
+func (s SVGRenderer) RenderLayout(layout *Layout, out io.Writer) error {
+	fontSize := s.FontSize
+	if fontSize == 0 {
+		fontSize = 14
+	}
+	w := &errWriter{w: out}
+	w.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %[1]s %[2]s">`+"\n", svgNum(layout.Width), svgNum(layout.Height))
+	w.printf(`<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto-start-reverse"><path d="M 0 0 L 10 5 L 0 10 z"/></marker></defs>` + "\n")
+	w.printf(`<g font-family="sans-serif" font-size="%g" text-anchor="middle">`+"\n", fontSize)
+	for _, edge := range layout.Edges {
+		points := make([]string, 0, len(edge.Points))
+		for _, p := range edge.Points {
+			points = append(points, fmt.Sprintf("%s,%s", svgNum(p.X), svgNum(p.Y)))
+		}
+		w.printf(`<polyline class="edge" points="%s" fill="none" stroke="black" marker-end="url(#arrow)"/>`+"\n", strings.Join(points, " "))
+		if lbl := s.edgeLabel(edge.Edge); lbl != "" && len(edge.Points) > 0 {
+			mid := edge.Points[len(edge.Points)/2]
+			if len(edge.Points)%2 == 0 {
+				prev := edge.Points[len(edge.Points)/2-1]
+				mid = Point{(prev.X + mid.X) / 2, (prev.Y + mid.Y) / 2}
+			}
+			w.printf(`<text class="edge-label" x="%s" y="%s">%s</text>`+"\n", svgNum(mid.X+fontSize/2), svgNum(mid.Y), xmlEscape(lbl))
+		}
+	}
+	for _, node := range layout.Nodes {
+		w.printf(`<rect class="node" x="%s" y="%s" width="%s" height="%s" rx="4" fill="white" stroke="black"/>`+"\n", svgNum(node.X-node.Width/2), svgNum(node.Y-node.Height/2), svgNum(node.Width), svgNum(node.Height))
+		if lbl := s.nodeLabel(node.Node); lbl != "" {
+			w.printf(`<text class="node-label" x="%s" y="%s" dominant-baseline="middle">%s</text>`+"\n", svgNum(node.X), svgNum(node.Y), xmlEscape(lbl))
+		}
+	}
+	w.printf("</g>\n</svg>\n")
+	return w.err
+}
+
+// svgNum formats a coordinate with at most two decimals
+func svgNum(x float64) string {
+	return strconv.FormatFloat(math.Round(x*100)/100, 'f', -1, 64)
+}
+
+// xmlEscape returns s escaped for XML text and attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// errWriter writes formatted output until the first error
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
@@ -0,0 +1,171 @@
+package digraph
+
+import (
+	"sync"
+)
+
+// SyncGraph wraps a Graph for concurrent use. Reads are performed
+// under a shared lock and can run concurrently, writes are
+// serialized. All slices returned from a SyncGraph are copies, so they
+// remain consistent after the graph is modified.
+//
+// The underlying graph must only be modified through the SyncGraph
+// once it is wrapped.
+type SyncGraph struct {
+	mu sync.RWMutex
+	g  *Graph
+
+	// ixMu protects the construction of ix. Once built, ix is
+	// read-only until the next write
+	ixMu sync.Mutex
+	ix   *Index
+}
+
+// NewSyncGraph returns a SyncGraph wrapping g. If g is nil, a new
+// graph is created.
+func NewSyncGraph(g *Graph) *SyncGraph {
+	if g == nil {
+		g = New()
+	}
+	return &SyncGraph{g: g}
+}
+
+// index returns a fully constructed index. Must be called with a read
+// lock held
+func (s *SyncGraph) index() *Index {
+	s.ixMu.Lock()
+	defer s.ixMu.Unlock()
+	if s.ix == nil {
+		ix := s.g.GetIndex()
+		ix.NodesSlice()
+		ix.NodesByLabelSlice(nil)
+		ix.InSlice(nil)
+		ix.InWithSlice(nil, nil)
+		s.ix = ix
+	}
+	return s.ix
+}
+
+// AddNode adds a node to the graph
+func (s *SyncGraph) AddNode(node Node) error {
+	return s.Update(func(g *Graph) error {
+		return g.TryAddNode(node)
+	})
+}
+
+// Connect connects two nodes with the given edge, enforcing the graph
+// policy
+func (s *SyncGraph) Connect(from, to Node, edge Edge) error {
+	return s.Update(func(g *Graph) error {
+		return g.Connect(from, to, edge)
+	})
+}
+
+// Disconnect removes an edge. Returns ErrNilEdge if edge is nil
+func (s *SyncGraph) Disconnect(edge Edge) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	return s.Update(func(g *Graph) error {
+		return g.Disconnect(edge)
+	})
+}
+
+// Relabel changes the label of an edge. Returns ErrNilEdge if edge is
+// nil
+func (s *SyncGraph) Relabel(edge Edge, label interface{}) error {
+	if edge == nil {
+		return ErrNilEdge
+	}
+	return s.Update(func(g *Graph) error {
+		return g.Relabel(edge, label)
+	})
+}
+
+// Update calls f with exclusive access to the underlying graph. Use
+// Update to perform a batch of modifications atomically. Returns the
+// error returned from f.
+func (s *SyncGraph) Update(f func(*Graph) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ix = nil
+	return f(s.g)
+}
+
+// View calls f with shared access to an index of the graph. The graph
+// and the nodes and edges reachable from the index must not be
+// modified in f. Returns the error returned from f.
+func (s *SyncGraph) View(f func(*Index) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return f(s.index())
+}
+
+// Snapshot returns a read-only snapshot of the graph that remains
+// valid while the graph is modified. Release the snapshot when it is
+// no longer needed
+func (s *SyncGraph) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.g.Snapshot()
+}
+
+// NodesSlice returns all accessible nodes
+func (s *SyncGraph) NodesSlice() []Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyNodes(s.index().NodesSlice())
+}
+
+// Nodes returns an iterator over a consistent snapshot of all
+// accessible nodes
+func (s *SyncGraph) Nodes() Nodes {
+	return NewNodeSliceIterator(s.NodesSlice()...)
+}
+
+// NodesByLabelSlice returns the nodes with the given label
+func (s *SyncGraph) NodesByLabelSlice(label interface{}) []Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyNodes(s.index().NodesByLabelSlice(label))
+}
+
+// OutSlice returns the outgoing edges of a node
+func (s *SyncGraph) OutSlice(node Node) []Edge {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return node.Out().All()
+}
+
+// OutWithSlice returns the outgoing edges of a node with a label
+func (s *SyncGraph) OutWithSlice(node Node, label interface{}) []Edge {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return node.OutWith(label).All()
+}
+
+// InSlice returns the incoming edges of a node
+func (s *SyncGraph) InSlice(node Node) []Edge {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyEdges(s.index().InSlice(node))
+}
+
+// InWithSlice returns the incoming edges of a node with a label
+func (s *SyncGraph) InWithSlice(node Node, label interface{}) []Edge {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyEdges(s.index().InWithSlice(node, label))
+}
+
+func copyNodes(nodes []Node) []Node {
+	ret := make([]Node, len(nodes))
+	copy(ret, nodes)
+	return ret
+}
+
+func copyEdges(edges []Edge) []Edge {
+	ret := make([]Edge, len(edges))
+	copy(ret, edges)
+	return ret
+}
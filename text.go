@@ -0,0 +1,386 @@
+package digraph
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// TextRenderer renders a graph as text, either as a box diagram or as
+// an indented tree
+type TextRenderer struct {
+	// NodeLabel returns the text of a node. If the node is to be
+	// excluded, returns false. If nil, the node label is printed using
+	// fmt.Sprint
+	NodeLabel func(Node) (string, bool)
+	// EdgeLabel returns the text of an edge. If the edge is to be
+	// excluded, returns false. If nil, the edge label is printed using
+	// fmt.Sprint, or nothing if the edge has no label
+	EdgeLabel func(Edge) (string, bool)
+	// ASCII selects ASCII characters instead of Unicode box drawing
+	// characters
+	ASCII bool
+	// NodeLess, if set, orders the nodes in the output
+	NodeLess func(a, b Node) bool
+	// EdgeLess, if set, orders the outgoing edges of a node in the
+	// output
+	EdgeLess func(a, b Edge) bool
+}
+
+func (t TextRenderer) nodeLabel(node Node) (string, bool) {
+	if t.NodeLabel != nil {
+		return t.NodeLabel(node)
+	}
+	return fmt.Sprint(node.GetLabel()), true
+}
+
+func (t TextRenderer) edgeLabel(edge Edge) (string, bool) {
+	if t.EdgeLabel != nil {
+		return t.EdgeLabel(edge)
+	}
+	if lbl := edge.GetLabel(); lbl != nil {
+		return fmt.Sprint(lbl), true
+	}
+	return "", true
+}
+
+// RenderTree writes the graph as an indented tree, starting from the
+// nodes without incoming edges. Each edge is written as a branch to
+// its target node, prefixed by the edge label in parentheses. A node
+// that is reached more than once is expanded only the first time, and
+// is marked with (*) afterwards. Nodes that are only part of cycles
+// are written as additional roots.
+func (t TextRenderer) RenderTree(g *Graph, out io.Writer) error {
+	allNodes, _ := renderNodeIDs(g, t.NodeLess)
+	labels := make(map[Node]string, len(allNodes))
+	for _, node := range allNodes {
+		if lbl, ok := t.nodeLabel(node); ok {
+			labels[node] = lineLabel(lbl)
+		}
+	}
+	hasIncoming := make(map[Node]struct{})
+	for _, node := range allNodes {
+		if _, ok := labels[node]; !ok {
+			continue
+		}
+		for edges := node.Out(); edges.HasNext(); {
+			edge := edges.Next()
+			if _, ok := t.edgeLabel(edge); ok && edge.GetTo() != node {
+				hasIncoming[edge.GetTo()] = struct{}{}
+			}
+		}
+	}
+	branch, last, pipe, space := "├── ", "└── ", "│   ", "    "
+	if t.ASCII {
+		branch, last, pipe = "|-- ", "`-- ", "|   "
+	}
+	w := &errWriter{w: out}
+	seen := make(map[Node]struct{})
+	var write func(node Node, prefix string)
+	write = func(node Node, prefix string) {
+		type child struct {
+			label string
+			node  Node
+		}
+		children := make([]child, 0)
+		for edges := renderOut(node, t.EdgeLess); edges.HasNext(); {
+			edge := edges.Next()
+			if _, ok := labels[edge.GetTo()]; !ok {
+				continue
+			}
+			if lbl, ok := t.edgeLabel(edge); ok {
+				children = append(children, child{label: lineLabel(lbl), node: edge.GetTo()})
+			}
+		}
+		for i, ch := range children {
+			b, p := branch, pipe
+			if i == len(children)-1 {
+				b, p = last, space
+			}
+			text := labels[ch.node]
+			if ch.label != "" {
+				text = "(" + ch.label + ") " + text
+			}
+			if _, ok := seen[ch.node]; ok {
+				w.printf("%s%s%s (*)\n", prefix, b, text)
+				continue
+			}
+			seen[ch.node] = struct{}{}
+			w.printf("%s%s%s\n", prefix, b, text)
+			write(ch.node, prefix+p)
+		}
+	}
+	writeRoot := func(node Node) {
+		seen[node] = struct{}{}
+		w.printf("%s\n", labels[node])
+		write(node, "")
+	}
+	for _, node := range allNodes {
+		if _, ok := labels[node]; !ok {
+			continue
+		}
+		if _, ok := hasIncoming[node]; !ok {
+			writeRoot(node)
+		}
+	}
+	for _, node := range allNodes {
+		if _, ok := labels[node]; !ok {
+			continue
+		}
+		if _, ok := seen[node]; !ok {
+			writeRoot(node)
+		}
+	}
+	return w.err
+}
+
+// Bits of a text grid cell that show the directions of the lines
+// passing through the cell
+const (
+	cellUp = 1 << iota
+	cellDown
+	cellLeft
+	cellRight
+)
+
+var unicodeLines = map[int]rune{
+	cellUp: '│', cellDown: '│', cellUp | cellDown: '│',
+	cellLeft: '─', cellRight: '─', cellLeft | cellRight: '─',
+	cellDown | cellRight: '┌', cellDown | cellLeft: '┐',
+	cellUp | cellRight: '└', cellUp | cellLeft: '┘',
+	cellUp | cellDown | cellRight: '├', cellUp | cellDown | cellLeft: '┤',
+	cellLeft | cellRight | cellDown: '┬', cellLeft | cellRight | cellUp: '┴',
+	cellUp | cellDown | cellLeft | cellRight: '┼',
+}
+
+// textGrid is a character grid that grows as needed
+type textGrid struct {
+	cells [][]rune
+	lines map[[2]int]int
+}
+
+func (g *textGrid) set(row, col int, r rune) {
+	if row < 0 || col < 0 {
+		return
+	}
+	for len(g.cells) <= row {
+		g.cells = append(g.cells, nil)
+	}
+	for len(g.cells[row]) <= col {
+		g.cells[row] = append(g.cells[row], ' ')
+	}
+	g.cells[row][col] = r
+}
+
+func (g *textGrid) get(row, col int) rune {
+	if row < 0 || col < 0 || row >= len(g.cells) || col >= len(g.cells[row]) {
+		return ' '
+	}
+	return g.cells[row][col]
+}
+
+// line marks the cells from (r1,c1) to (r2,c2) as connected. The
+// cells must be on the same row or column
+func (g *textGrid) line(r1, c1, r2, c2 int) {
+	for r1 != r2 || c1 != c2 {
+		r, c := r1, c1
+		var out, in int
+		switch {
+		case r2 > r1:
+			r, out, in = r1+1, cellDown, cellUp
+		case r2 < r1:
+			r, out, in = r1-1, cellUp, cellDown
+		case c2 > c1:
+			c, out, in = c1+1, cellRight, cellLeft
+		default:
+			c, out, in = c1-1, cellLeft, cellRight
+		}
+		g.lines[[2]int{r1, c1}] |= out
+		g.lines[[2]int{r, c}] |= in
+		r1, c1 = r, c
+	}
+}
+
+// RenderBoxes writes the graph as a box diagram using the layered
+// layout. Nodes are drawn as boxes containing their labels, and edges
+// as lines ending with an arrow. Self loops are not drawn.
+func (t TextRenderer) RenderBoxes(g *Graph, out io.Writer) error {
+	labels := make(map[Node]string)
+	layout := LayoutGraph(g, LayoutOptions{
+		NodeSize: func(node Node) (float64, float64) {
+			return float64(len([]rune(labels[node])) + 4), 3
+		},
+		LayerSpacing: 3,
+		NodeSpacing:  2,
+		Margin:       0.5,
+		NodeLess:     t.NodeLess,
+		Filter: func(node Node) bool {
+			lbl, ok := t.nodeLabel(node)
+			if ok {
+				labels[node] = lineLabel(lbl)
+			}
+			return ok
+		},
+	})
+	grid := &textGrid{lines: make(map[[2]int]int)}
+	type cell struct{ row, col int }
+	round := func(x float64) int { return int(math.Floor(x)) }
+	top := func(n NodeLayout) int { return round(n.Y - n.Height/2) }
+	arrows := make(map[cell]rune)
+	type edgeLabel struct {
+		pos  cell
+		text string
+	}
+	edgeLabels := make([]edgeLabel, 0)
+	type route struct {
+		points   []cell
+		reversed bool
+		label    string
+	}
+	// A port is where an edge leaves or enters a box. Ports on the same
+	// side of a box are spread over the width of the box, ordered by
+	// the position of the other end
+	type port struct {
+		route *route
+		index int
+		x     float64
+	}
+	type side struct {
+		node  Node
+		below bool
+	}
+	ports := make(map[side][]port)
+	routes := make([]*route, 0, len(layout.Edges))
+	for _, e := range layout.Edges {
+		from, _ := layout.NodeLayout(e.Edge.GetFrom())
+		to, _ := layout.NodeLayout(e.Edge.GetTo())
+		lbl, ok := t.edgeLabel(e.Edge)
+		if !ok || from.Node == to.Node {
+			continue
+		}
+		r := &route{reversed: e.Reversed, label: lineLabel(lbl)}
+		for i, p := range e.Points {
+			r.points = append(r.points, cell{round(p.Y), round(p.X)})
+			var n NodeLayout
+			var other Point
+			switch i {
+			case 0:
+				n, other = from, e.Points[1]
+			case len(e.Points) - 1:
+				n, other = to, e.Points[i-1]
+			default:
+				continue
+			}
+			key := side{n.Node, other.Y > n.Y}
+			if key.below {
+				r.points[i].row = top(n) + int(n.Height)
+			} else {
+				r.points[i].row = top(n) - 1
+			}
+			ports[key] = append(ports[key], port{route: r, index: i, x: other.X})
+		}
+		routes = append(routes, r)
+	}
+	for key, list := range ports {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].x < list[j].x })
+		n, _ := layout.NodeLayout(key.node)
+		left, inner := round(n.X-n.Width/2), int(n.Width)-2
+		for k, p := range list {
+			p.route.points[p.index].col = left + 1 + (k+1)*inner/(len(list)+1)
+		}
+	}
+	for _, r := range routes {
+		points := r.points
+		for i := 1; i < len(points); i++ {
+			p, q := points[i-1], points[i]
+			mid := (p.row + q.row) / 2
+			grid.line(p.row, p.col, mid, p.col)
+			grid.line(mid, p.col, mid, q.col)
+			grid.line(mid, q.col, q.row, q.col)
+		}
+		end := points[len(points)-1]
+		if r.reversed {
+			arrows[end] = '▲'
+		} else {
+			arrows[end] = '▼'
+		}
+		if r.label != "" {
+			edgeLabels = append(edgeLabels, edgeLabel{cell{end.row, end.col + 1}, r.label})
+		}
+	}
+	for pos, bits := range grid.lines {
+		r := unicodeLines[bits]
+		if t.ASCII {
+			switch r {
+			case '│':
+				r = '|'
+			case '─':
+				r = '-'
+			default:
+				r = '+'
+			}
+		}
+		grid.set(pos[0], pos[1], r)
+	}
+	for pos, r := range arrows {
+		if t.ASCII {
+			r = map[rune]rune{'▲': '^', '▼': 'v'}[r]
+		}
+		grid.set(pos.row, pos.col, r)
+	}
+	corners := []rune("┌┐└┘─│")
+	if t.ASCII {
+		corners = []rune("++++-|")
+	}
+	for _, n := range layout.Nodes {
+		r0, c0 := top(n), round(n.X-n.Width/2)
+		w, h := int(n.Width), int(n.Height)
+		for c := 1; c < w-1; c++ {
+			grid.set(r0, c0+c, corners[4])
+			grid.set(r0+h-1, c0+c, corners[4])
+		}
+		for r := 1; r < h-1; r++ {
+			grid.set(r0+r, c0, corners[5])
+			grid.set(r0+r, c0+w-1, corners[5])
+			for c := 1; c < w-1; c++ {
+				grid.set(r0+r, c0+c, ' ')
+			}
+		}
+		grid.set(r0, c0, corners[0])
+		grid.set(r0, c0+w-1, corners[1])
+		grid.set(r0+h-1, c0, corners[2])
+		grid.set(r0+h-1, c0+w-1, corners[3])
+		for i, r := range []rune(labels[n.Node]) {
+			grid.set(r0+h/2, c0+2+i, r)
+		}
+	}
+	// Edge labels are written only where they do not overwrite
+	// anything
+	for _, lbl := range edgeLabels {
+		pos, text := lbl.pos, []rune(lbl.text)
+		free := true
+		for i := range text {
+			if grid.get(pos.row, pos.col+i) != ' ' {
+				free = false
+				break
+			}
+		}
+		if free {
+			for i, r := range text {
+				grid.set(pos.row, pos.col+i, r)
+			}
+		}
+	}
+	w := &errWriter{w: out}
+	for _, row := range grid.cells {
+		w.printf("%s\n", strings.TrimRight(string(row), " "))
+	}
+	return w.err
+}
+
+// lineLabel returns the label in a single line
+func lineLabel(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(s)
+}
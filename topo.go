@@ -0,0 +1,205 @@
+package digraph
+
+// topoOrder is a topological order of the nodes of an acyclic graph
+// that is maintained incrementally as edges are added, using the
+// algorithm of Marchetti-Spaccamela, Nanni, and Rohnert. Each node has
+// a position, and every edge goes from a lower position to a higher
+// one. When an edge goes against the order, only the nodes between
+// the positions of its endpoints are visited and reordered.
+//
+// Positions need not be contiguous. Nodes discovered through an edge
+// are placed before all known nodes, so their edges to known nodes
+// agree with the order.
+//
+// Edges added to the nodes of other graphs are not reported to the
+// graph, so the order cannot be maintained once it contains such
+// nodes.
+type topoOrder struct {
+	g   *Graph
+	pos map[Node]int
+	at  map[int]Node
+	// lo is the lowest position
+	lo int
+	// foreign is true if the order contains a node that belongs to
+	// another graph
+	foreign bool
+}
+
+// newTopoOrder returns a topological order of all nodes accessible in
+// g, or nil if g has a cycle
+func newTopoOrder(g *Graph) *topoOrder {
+	t := &topoOrder{g: g, pos: make(map[Node]int), at: make(map[int]Node)}
+	for _, root := range g.roots() {
+		if !t.discover(root) {
+			return nil
+		}
+	}
+	return t
+}
+
+// discover adds node and the unknown nodes accessible from it to the
+// order. Returns false without changing the order if the unknown
+// nodes have a cycle.
+func (t *topoOrder) discover(node Node) bool {
+	if _, ok := t.pos[node]; ok {
+		return true
+	}
+	type frame struct {
+		node Node
+		next []Node
+	}
+	// onStack is true for the nodes on the DFS stack, and false for
+	// finished nodes
+	onStack := map[Node]bool{node: true}
+	stack := []frame{{node: node, next: node.Next()}}
+	postorder := make([]Node, 0)
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if len(top.next) == 0 {
+			onStack[top.node] = false
+			postorder = append(postorder, top.node)
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		next := top.next[0]
+		top.next = top.next[1:]
+		if _, ok := t.pos[next]; ok {
+			continue
+		}
+		active, seen := onStack[next]
+		if active {
+			return false
+		}
+		if !seen {
+			onStack[next] = true
+			stack = append(stack, frame{node: next, next: next.Next()})
+		}
+	}
+	// A node is finished after all nodes accessible from it, so
+	// assigning descending positions in postorder puts every node
+	// before its successors
+	for _, n := range postorder {
+		if owner := n.getNodeHeader().graph; owner != nil && owner != t.g {
+			t.foreign = true
+		}
+		t.lo--
+		t.pos[n] = t.lo
+		t.at[t.lo] = n
+	}
+	return true
+}
+
+// affected returns the nodes accessible from node whose positions are
+// not greater than ub, and whether target is one of them
+func (t *topoOrder) affected(node, target Node, ub int) (map[Node]struct{}, bool) {
+	seen := map[Node]struct{}{node: {}}
+	stack := []Node{node}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range n.Next() {
+			if next == target {
+				return seen, true
+			}
+			if _, ok := seen[next]; ok {
+				continue
+			}
+			if p, ok := t.pos[next]; ok && p <= ub {
+				seen[next] = struct{}{}
+				stack = append(stack, next)
+			}
+		}
+	}
+	return seen, false
+}
+
+// createsCycle returns true if an edge from -> to would create a
+// cycle. The second return value is false if the nodes cannot be
+// added to the order because they are part of an existing cycle.
+func (t *topoOrder) createsCycle(from, to Node) (bool, bool) {
+	if from == to {
+		return true, true
+	}
+	if !t.discover(from) || !t.discover(to) {
+		return false, false
+	}
+	if t.pos[from] < t.pos[to] {
+		return false, true
+	}
+	_, cycle := t.affected(to, from, t.pos[from])
+	return cycle, true
+}
+
+// addEdge updates the order after an edge from -> to is connected.
+// Returns false if the edge created a cycle, in which case the order
+// is no longer valid.
+func (t *topoOrder) addEdge(from, to Node) bool {
+	if from == to || !t.discover(from) || !t.discover(to) {
+		return false
+	}
+	lb, ub := t.pos[to], t.pos[from]
+	if ub < lb {
+		return true
+	}
+	// to must be after from. Place the nodes of the affected region
+	// that are accessible from to after the remaining nodes of the
+	// region, keeping their relative order
+	moved, cycle := t.affected(to, from, ub)
+	if cycle {
+		return false
+	}
+	positions := make([]int, 0)
+	before := make([]Node, 0)
+	after := make([]Node, 0, len(moved))
+	for p := lb; p <= ub; p++ {
+		n, ok := t.at[p]
+		if !ok {
+			continue
+		}
+		positions = append(positions, p)
+		if _, ok := moved[n]; ok {
+			after = append(after, n)
+		} else {
+			before = append(before, n)
+		}
+	}
+	for i, n := range append(before, after...) {
+		t.pos[n] = positions[i]
+		t.at[positions[i]] = n
+	}
+	return true
+}
+
+// remove removes a node from the order
+func (t *topoOrder) remove(node Node) {
+	if p, ok := t.pos[node]; ok {
+		delete(t.pos, node)
+		delete(t.at, p)
+	}
+}
+
+// createsCycle returns true if connecting from -> to would create a
+// cycle in the graph. The check uses the topological order of the
+// graph, which is built on first use and maintained as edges are
+// connected to the nodes of the graph. If the graph already has a
+// cycle, or if the order reaches nodes of other graphs, the nodes are
+// searched instead.
+func (g *Graph) createsCycle(from, to Node) bool {
+	if g.topo == nil {
+		g.topo = newTopoOrder(g)
+	}
+	if g.topo != nil && !g.topo.foreign {
+		if cycle, ok := g.topo.createsCycle(from, to); ok && !g.topo.foreign {
+			return cycle
+		}
+	}
+	return Reachable(to, from)
+}
+
+// edgeAdded updates the topological order of the graph after an edge
+// is connected
+func (g *Graph) edgeAdded(from, to Node) {
+	if g.topo != nil && !g.topo.addEdge(from, to) {
+		g.topo = nil
+	}
+}